@@ -0,0 +1,82 @@
+package commands
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/git-lfs/git-lfs/config"
+	"github.com/git-lfs/git-lfs/tq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterProcessSmudgePromptsOncePerHost(t *testing.T) {
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass"))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != auth {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			Objects []*tq.Transfer `json:"objects"`
+		}
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&tq.BatchResponse{
+			TransferAdapterName: "basic",
+			Objects:             req.Objects,
+		})
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "filter-process-creds")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// The credential helper logs each action, and prompts for credentials
+	// whenever they are requested.
+	calls := filepath.Join(dir, "calls")
+	program := filepath.Join(dir, "git-credential-prompt")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$1\" >> %q\nwhile read line; do :; done\n"+
+		"if [ \"$1\" = get ]; then\necho username=user\necho password=pass\nfi\n", calls)
+	require.Nil(t, ioutil.WriteFile(program, []byte(script), 0755))
+
+	oldcfg, oldclient, oldmanifest := cfg, apiClient, tqManifest
+	defer func() { cfg, apiClient, tqManifest = oldcfg, oldclient, oldmanifest }()
+
+	cfg = config.NewFrom(config.Values{
+		Git: map[string][]string{
+			"lfs.url": []string{srv.URL + "/repo/lfs"},
+			fmt.Sprintf("lfs.%s/repo/lfs.access", srv.URL): []string{"basic"},
+			"lfs.credentialhelperprogram":                  []string{program},
+		},
+	})
+	apiClient = nil
+	tqManifest = make(map[string]*tq.Manifest)
+
+	// Each object smudged by the filter process fetches the manifest
+	// anew, as smudge does.
+	for _, oid := range []string{"a", "b"} {
+		manifest := getTransferManifestOperationRemote("download", cfg.Remote())
+		res, err := tq.Batch(manifest, tq.Download, cfg.Remote(), nil, []*tq.Transfer{
+			&tq.Transfer{Oid: oid, Size: 1},
+		})
+		require.Nil(t, err)
+		if assert.Len(t, res.Objects, 1) {
+			assert.Equal(t, oid, res.Objects[0].Oid)
+		}
+	}
+
+	by, err := ioutil.ReadFile(calls)
+	require.Nil(t, err)
+	assert.Equal(t, 1, strings.Count(string(by), "get\n"))
+}
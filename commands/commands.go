@@ -14,12 +14,14 @@ import (
 	"time"
 
 	"github.com/git-lfs/git-lfs/config"
+	"github.com/git-lfs/git-lfs/creds"
 	"github.com/git-lfs/git-lfs/errors"
 	"github.com/git-lfs/git-lfs/filepathfilter"
 	"github.com/git-lfs/git-lfs/git"
 	"github.com/git-lfs/git-lfs/lfs"
 	"github.com/git-lfs/git-lfs/lfsapi"
 	"github.com/git-lfs/git-lfs/locking"
+	"github.com/git-lfs/git-lfs/subprocess"
 	"github.com/git-lfs/git-lfs/tools"
 	"github.com/git-lfs/git-lfs/tq"
 )
@@ -66,6 +68,9 @@ func getTransferManifestOperationRemote(operation, remote string) *tq.Manifest {
 	return tqManifest[k]
 }
 
+// getAPIClient returns the API client shared by the whole process, so that
+// credentials are cached, and prompted for, once per host however many requests
+// need them, such as for every object smudged by a long-running filter-process.
 func getAPIClient() *lfsapi.Client {
 	global.Lock()
 	defer global.Unlock()
@@ -76,6 +81,10 @@ func getAPIClient() *lfsapi.Client {
 			ExitWithError(err)
 		}
 		apiClient = c
+
+		// The Git LFS processes run by the Git commands this one runs,
+		// such as filters, are told which credentials were filled.
+		subprocess.SetEnvFunc(creds.CredentialHandoffEnv, c.CredentialHandoff)
 	}
 	return apiClient
 }
@@ -269,6 +278,7 @@ func Panic(err error, format string, args ...interface{}) {
 }
 
 func Cleanup() {
+	creds.RestoreTerminals()
 	if err := cfg.Cleanup(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error clearing old temp files: %s\n", err)
 	}
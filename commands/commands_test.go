@@ -47,3 +47,21 @@ func TestDetermineIncludeExcludePathsReturnsNothingWhenAbsent(t *testing.T) {
 	assert.Empty(t, i)
 	assert.Empty(t, e)
 }
+
+func TestReadStdinCredentialRefusesStdinCommands(t *testing.T) {
+	enabled := config.NewFrom(config.Values{
+		Git: map[string][]string{
+			"lfs.credentialfromstdin": []string{"true"},
+		},
+	})
+
+	for _, name := range []string{"clean", "filter-process", "pre-push", "smudge"} {
+		err := readStdinCredential(enabled, name)
+		if assert.NotNil(t, err, name) {
+			assert.Contains(t, err.Error(), "git lfs "+name)
+		}
+	}
+
+	// without lfs.credentialFromStdin, standard input is left alone
+	assert.Nil(t, readStdinCredential(testcfg, "smudge"))
+}
@@ -10,6 +10,8 @@ import (
 	"time"
 
 	"github.com/git-lfs/git-lfs/config"
+	"github.com/git-lfs/git-lfs/creds"
+	"github.com/git-lfs/git-lfs/errors"
 	"github.com/git-lfs/git-lfs/tools"
 	"github.com/spf13/cobra"
 )
@@ -27,7 +29,13 @@ var (
 // Each command will initialize the local storage ('.git/lfs') directory when
 // run, unless the PreRun hook is set to nil.
 func NewCommand(name string, runFn func(*cobra.Command, []string)) *cobra.Command {
-	return &cobra.Command{Use: name, Run: runFn, PreRun: setupHTTPLogger}
+	return &cobra.Command{Use: name, Run: runFn, PreRun: setupCommand}
+}
+
+// setupCommand prepares to run the given command.
+func setupCommand(cmd *cobra.Command, args []string) {
+	setupHTTPLogger(cmd, args)
+	setupStdinCredential(cmd, args)
 }
 
 // RegisterCommand creates a direct 'git-lfs' subcommand, given a command name,
@@ -159,3 +167,38 @@ func setupHTTPLogger(cmd *cobra.Command, args []string) {
 		getAPIClient().LogHTTPStats(file)
 	}
 }
+
+// stdinCommands are the commands which read their own input from standard
+// input, such as the content or pointers Git gives a filter, or the refs given
+// to the pre-push hook.
+var stdinCommands = map[string]bool{
+	"clean":           true,
+	"filter-process":  true,
+	"pre-push":        true,
+	"smudge":          true,
+	"standalone-file": true,
+}
+
+// setupStdinCredential reads the credential given on standard input, if
+// "lfs.credentialFromStdin" is enabled, before anything else reads from it.
+func setupStdinCredential(cmd *cobra.Command, args []string) {
+	if err := readStdinCredential(cfg, cmd.Name()); err != nil {
+		ExitWithError(err)
+	}
+}
+
+// readStdinCredential reads the credential given on standard input for the
+// named command, if "lfs.credentialFromStdin" is enabled, or refuses to if the
+// command reads its own input from standard input. Any error reading the
+// credential is only returned once credentials are needed.
+func readStdinCredential(cfg *config.Configuration, name string) error {
+	if !cfg.Git.Bool("lfs.credentialfromstdin", false) {
+		return nil
+	}
+	if stdinCommands[name] {
+		return errors.Errorf("lfs.credentialFromStdin cannot be used with 'git lfs %s', which reads its own input from standard input", name)
+	}
+
+	creds.ReadStdinCredential()
+	return nil
+}
@@ -51,6 +51,18 @@ func (c *URLConfig) GetAll(prefix, rawurl, key string) []string {
 	return c.git.GetAll(strings.Join([]string{prefix, key}, "."))
 }
 
+// GetAllForURL works like GetAll, but without the fallback to `http.{key}`,
+// returning the values of the `http.{url}.{key}` which best matches the given
+// URL, and whether there is one.
+func (c *URLConfig) GetAllForURL(prefix, rawurl, key string) ([]string, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	v := c.getAll(strings.ToLower(prefix), rawurl, strings.ToLower(key))
+	return v, len(v) > 0
+}
+
 func (c *URLConfig) Bool(prefix, rawurl, key string, def bool) bool {
 	s, _ := c.Get(prefix, rawurl, key)
 	return Bool(s, def)
@@ -58,10 +70,11 @@ func (c *URLConfig) Bool(prefix, rawurl, key string, def bool) bool {
 
 func (c *URLConfig) getAll(prefix, rawurl, key string) []string {
 	type urlMatch struct {
-		key       string // The full configuration key
-		hostScore int    // A score indicating the strength of the host match
-		pathScore int    // A score indicating the strength of the path match
-		userMatch int    // Whether we matched on a username. 1 for yes, else 0
+		key        string // The full configuration key
+		hostScore  int    // A score indicating the strength of the host match
+		hostSuffix int    // The number of trailing host labels matched exactly
+		pathScore  int    // A score indicating the strength of the path match
+		userMatch  int    // Whether we matched on a username. 1 for yes, else 0
 	}
 
 	searchURL, err := url.Parse(rawurl)
@@ -140,22 +153,41 @@ func (c *URLConfig) getAll(prefix, rawurl, key string) []string {
 			match.userMatch = 1
 		}
 
+		match.hostSuffix = hostSuffix(configURL.Hostname())
+
 		// Now combine our various scores to determine if we have found a best
-		// match. Host score > path score > user score
-		if match.hostScore > bestMatch.hostScore {
+		// match. Host score > host suffix > path score > user score. Since
+		// the configuration is not ordered, any remaining tie is broken by
+		// the configuration key, so that the match is deterministic.
+		if match.hostScore != bestMatch.hostScore {
 			bestMatch = match
 			continue
 		}
 
-		if match.pathScore > bestMatch.pathScore {
-			bestMatch = match
+		if match.hostSuffix != bestMatch.hostSuffix {
+			if match.hostSuffix > bestMatch.hostSuffix {
+				bestMatch = match
+			}
 			continue
 		}
 
-		if match.pathScore == bestMatch.pathScore && match.userMatch > bestMatch.userMatch {
-			bestMatch = match
+		if match.pathScore != bestMatch.pathScore {
+			if match.pathScore > bestMatch.pathScore {
+				bestMatch = match
+			}
+			continue
+		}
+
+		if match.userMatch != bestMatch.userMatch {
+			if match.userMatch > bestMatch.userMatch {
+				bestMatch = match
+			}
 			continue
 		}
+
+		if len(bestMatch.key) == 0 || match.key < bestMatch.key {
+			bestMatch = match
+		}
 	}
 
 	if bestMatch.key == "" {
@@ -192,6 +224,19 @@ func compareHosts(searchHostname, configHostname string) int {
 	return score
 }
 
+// hostSuffix returns the number of labels at the end of a configuration
+// hostname which are not wildcards, so that, among wildcard matches with the
+// same number of wildcards, "*.example.com" is preferred to "foo.*.com".
+func hostSuffix(configHostname string) int {
+	configHost := strings.Split(configHostname, ".")
+
+	suffix := 0
+	for i := len(configHost) - 1; i >= 0 && configHost[i] != "*"; i-- {
+		suffix++
+	}
+	return suffix
+}
+
 // comparePaths compares a path with a configuration path to determine a match.
 // It returns an integer indicating the strength of the match, or 0 if the two
 // paths did not match.
@@ -60,3 +60,61 @@ func TestURLConfig(t *testing.T) {
 		assert.Equal(t, expected, values, "get all: "+rawurl)
 	}
 }
+
+func TestURLConfigGetAllForURL(t *testing.T) {
+	u := NewURLConfig(EnvironmentOf(MapFetcher(map[string][]string{
+		"credential.helper":                   []string{"root"},
+		"credential.https://host.com.helper":  []string{"root"},
+		"credential.https://other.com.helper": []string{""},
+	})))
+
+	values, ok := u.GetAllForURL("credential", "https://host.com/repo", "helper")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"root"}, values)
+
+	values, ok = u.GetAllForURL("credential", "https://other.com/repo", "helper")
+	assert.True(t, ok)
+	assert.Equal(t, []string{""}, values)
+
+	values, ok = u.GetAllForURL("credential", "https://root.com/repo", "helper")
+	assert.False(t, ok)
+	assert.Empty(t, values)
+}
+
+func TestURLConfigWildcardPrecedence(t *testing.T) {
+	u := NewURLConfig(EnvironmentOf(MapFetcher(map[string][]string{
+		"credential.helper":                           []string{"generic"},
+		"credential.https://*.example.com.helper":     []string{"subdomain"},
+		"credential.https://foo.*.com.helper":         []string{"middle"},
+		"credential.https://*.*.com.helper":           []string{"two wildcards"},
+		"credential.https://bar.example.com.helper":   []string{"exact"},
+		"credential.https://*.example.com/org.helper": []string{"subdomain org"},
+		"credential.https://a.*.org.helper":           []string{"a"},
+		"credential.https://*.b.org.helper":           []string{"b"},
+		"credential.https://*.c.org.helper":           []string{"c"},
+		"credential.https://*.c.org/.helper":          []string{"c/"},
+	})))
+
+	for desc, c := range map[string]struct {
+		URL      string
+		Expected string
+	}{
+		"exact over wildcard":        {"https://bar.example.com/repo", "exact"},
+		"literal suffix over prefix": {"https://foo.example.com/repo", "subdomain"},
+		"fewer wildcards":            {"https://baz.example.com/repo", "subdomain"},
+		"path over host":             {"https://baz.example.com/org/repo", "subdomain org"},
+		"middle wildcard":            {"https://foo.other.com/repo", "middle"},
+		"most wildcards":             {"https://baz.other.com/repo", "two wildcards"},
+		"label count must match":     {"https://a.baz.example.com/repo", "generic"},
+		"bare domain is not matched": {"https://example.com/repo", "generic"},
+		"suffix beats prefix":        {"https://a.b.org/repo", "b"},
+		"tie broken by key":          {"https://x.c.org/repo", "c"},
+	} {
+		t.Run(desc, func(t *testing.T) {
+			for i := 0; i < 10; i++ {
+				value, _ := u.Get("credential", c.URL, "helper")
+				assert.Equal(t, c.Expected, value)
+			}
+		})
+	}
+}
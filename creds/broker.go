@@ -0,0 +1,273 @@
+package creds
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/git-lfs/git-lfs/config"
+	"github.com/git-lfs/git-lfs/errors"
+	"github.com/rubyist/tracerx"
+)
+
+// BrokerCredentialHelper is a CredentialHelper which requests credentials from
+// an HTTP token broker, and is consulted for every URL with a
+// "credential.<url>.brokerEndpoint".
+//
+// To fill credentials, it POSTs a JSON object with the "action" "get", and
+// the "protocol", "host", and, if known, "path" and "username" of the
+// requested credentials, to the endpoint, which answers with a JSON object
+// holding their "username", "password", and optionally "expiry", as either a
+// Unix timestamp or an RFC 3339 time. An answer of HTTP 404 or 204 means the
+// broker has no credentials, and the next credential helper is consulted.
+//
+// If "credential.<url>.brokerNotify" is enabled, approved and rejected
+// credentials are reported to the endpoint in the same way, with the "action"
+// "approve" or "reject", and without their password. Either way, they are not
+// passed on to the other credential helpers, since the broker owns them.
+//
+// A client certificate and key for mutual TLS may be given with
+// "credential.<url>.brokerSslCert" and "credential.<url>.brokerSslKey", and
+// the certificate authorities with which to verify the broker with
+// "credential.<url>.brokerSslCAInfo".
+type BrokerCredentialHelper struct {
+	urlConfig *config.URLConfig
+
+	// newClient returns the HTTP client for requests to the given URL,
+	// before the broker's TLS files are applied to it.
+	newClient func(rawurl string) (*http.Client, error)
+
+	// clients holds an HTTP client for each endpoint and combination of
+	// TLS files, so that connections are reused.
+	clients map[string]*http.Client
+	mu      sync.Mutex
+}
+
+func newBrokerCredentialHelper(urlConfig *config.URLConfig, newClient func(string) (*http.Client, error)) *BrokerCredentialHelper {
+	return &BrokerCredentialHelper{
+		urlConfig: urlConfig,
+		newClient: newClient,
+		clients:   make(map[string]*http.Client),
+	}
+}
+
+func (h *BrokerCredentialHelper) Name() string { return "broker" }
+
+// brokerRequest is the body of each request to the broker.
+type brokerRequest struct {
+	Action   string `json:"action"`
+	Protocol string `json:"protocol"`
+	Host     string `json:"host"`
+	Path     string `json:"path,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+func (h *BrokerCredentialHelper) Fill(what Creds) (Creds, error) {
+	endpoint, ok := h.endpoint(what)
+	if !ok {
+		return nil, credHelperNoOp
+	}
+
+	res, err := h.post(endpoint, "get", what)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusNoContent:
+		return nil, credHelperNoOp
+	default:
+		return nil, errors.Errorf("broker: requesting credentials from %s: HTTP %d", endpoint, res.StatusCode)
+	}
+
+	var answer struct {
+		Username string      `json:"username"`
+		Password string      `json:"password"`
+		Expiry   interface{} `json:"expiry"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&answer); err != nil {
+		return nil, errors.Wrapf(err, "broker: decoding credentials from %s", endpoint)
+	}
+	if len(answer.Password) == 0 {
+		return nil, errors.Errorf("broker: credentials from %s have no %q", endpoint, "password")
+	}
+
+	creds := make(Creds)
+	creds[CredsProtocol] = what[CredsProtocol]
+	creds[CredsHost] = what[CredsHost]
+	if p, ok := what[CredsPath]; ok {
+		creds[CredsPath] = p
+	}
+	if len(answer.Username) > 0 {
+		creds[CredsUsername] = answer.Username
+	} else {
+		creds[CredsUsername] = what[CredsUsername]
+	}
+	creds[CredsPassword] = answer.Password
+
+	switch expiry := answer.Expiry.(type) {
+	case nil:
+	case float64:
+		creds[CredsPasswordExpiryUTC] = strconv.FormatInt(int64(expiry), 10)
+	case string:
+		t, err := parsePasswordExpiry(expiry)
+		if err != nil {
+			return nil, errors.Wrapf(err, "broker: credentials from %s", endpoint)
+		}
+		creds[CredsPasswordExpiryUTC] = strconv.FormatInt(t.Unix(), 10)
+	default:
+		return nil, errors.Errorf("broker: credentials from %s have an invalid %q", endpoint, "expiry")
+	}
+
+	tracerx.Printf("creds: filled credentials from broker %s", endpoint)
+	return creds, nil
+}
+
+// Approve implements CredentialHelper.Approve, and notifies the broker if
+// "credential.<url>.brokerNotify" is enabled.
+func (h *BrokerCredentialHelper) Approve(creds Creds) error {
+	return h.notify("approve", creds)
+}
+
+// Reject implements CredentialHelper.Reject, and notifies the broker if
+// "credential.<url>.brokerNotify" is enabled.
+func (h *BrokerCredentialHelper) Reject(creds Creds) error {
+	return h.notify("reject", creds)
+}
+
+func (h *BrokerCredentialHelper) notify(action string, creds Creds) error {
+	endpoint, ok := h.endpoint(creds)
+	if !ok {
+		return credHelperNoOp
+	}
+	if !h.urlConfig.Bool("credential", configURL(creds), "brokernotify", false) {
+		return nil
+	}
+
+	res, err := h.post(endpoint, action, creds)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return errors.Errorf("broker: notifying %s of %s: HTTP %d", endpoint, action, res.StatusCode)
+	}
+	return nil
+}
+
+// endpoint returns the "credential.<url>.brokerEndpoint" configured for the
+// URL of the given Creds, if any.
+func (h *BrokerCredentialHelper) endpoint(creds Creds) (string, bool) {
+	endpoint, ok := h.urlConfig.Get("credential", configURL(creds), "brokerendpoint")
+	return endpoint, ok && len(endpoint) > 0
+}
+
+func (h *BrokerCredentialHelper) post(endpoint, action string, creds Creds) (*http.Response, error) {
+	by, err := json.Marshal(brokerRequest{
+		Action:   action,
+		Protocol: creds[CredsProtocol],
+		Host:     creds[CredsHost],
+		Path:     creds[CredsPath],
+		Username: creds[CredsUsername],
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := h.client(endpoint, creds)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Post(endpoint, "application/json", bytes.NewReader(by))
+	if err != nil {
+		return nil, errors.Wrapf(err, "broker: %s", action)
+	}
+	return res, nil
+}
+
+// client returns the HTTP client with which to reach the given broker endpoint
+// for the URL of the given Creds. It uses the same proxy and TLS configuration
+// as other requests to the endpoint, with the client certificate and
+// certificate authorities configured for the broker, if any.
+func (h *BrokerCredentialHelper) client(endpoint string, creds Creds) (*http.Client, error) {
+	rawurl := configURL(creds)
+	certFile, _ := h.urlConfig.Get("credential", rawurl, "brokersslcert")
+	keyFile, _ := h.urlConfig.Get("credential", rawurl, "brokersslkey")
+	caFile, _ := h.urlConfig.Get("credential", rawurl, "brokersslcainfo")
+	key := endpoint + "\x00" + certFile + "\x00" + keyFile + "\x00" + caFile
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if client, ok := h.clients[key]; ok {
+		return client, nil
+	}
+
+	client, err := h.newClient(endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "broker")
+	}
+	if len(certFile) > 0 || len(keyFile) > 0 || len(caFile) > 0 {
+		tr, err := brokerTransport(client.Transport)
+		if err != nil {
+			return nil, err
+		}
+		if err := applyBrokerTLS(tr, certFile, keyFile, caFile); err != nil {
+			return nil, err
+		}
+		client.Transport = tr
+	}
+	h.clients[key] = client
+	return client, nil
+}
+
+// brokerTransport returns the given transport as an *http.Transport, to which
+// the broker's TLS files may be applied, or a new one with the proxy from the
+// environment if it is nil.
+func brokerTransport(rt http.RoundTripper) (*http.Transport, error) {
+	if rt == nil {
+		return &http.Transport{Proxy: http.ProxyFromEnvironment}, nil
+	}
+	tr, ok := rt.(*http.Transport)
+	if !ok {
+		return nil, errors.Errorf("broker: cannot apply TLS configuration to transport %T", rt)
+	}
+	return tr, nil
+}
+
+// applyBrokerTLS configures the given transport with the client certificate
+// and key, and the certificate authorities, in the given files, if any.
+func applyBrokerTLS(tr *http.Transport, certFile, keyFile, caFile string) error {
+	tlsConfig := &tls.Config{}
+	if tr.TLSClientConfig != nil {
+		tlsConfig = tr.TLSClientConfig.Clone()
+	}
+	if len(certFile) > 0 || len(keyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return errors.Wrap(err, "broker: loading client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if len(caFile) > 0 {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return errors.Wrap(err, "broker: reading certificate authorities")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.Errorf("broker: no certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	tr.TLSClientConfig = tlsConfig
+	return nil
+}
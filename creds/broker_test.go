@@ -0,0 +1,326 @@
+package creds
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/git-lfs/git-lfs/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newMockBroker returns a broker which answers requests for each host in the
+// given map with its answer, and HTTP 404 for any other, and the requests it
+// was sent.
+func newMockBroker(t *testing.T, answers map[string]string) (*httptest.Server, *[]brokerRequest) {
+	var requests []brokerRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var req brokerRequest
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		requests = append(requests, req)
+
+		if req.Action != "get" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		answer, ok := answers[req.Host]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(answer))
+	}))
+	return srv, &requests
+}
+
+func TestBrokerCredentialHelperFill(t *testing.T) {
+	srv, requests := newMockBroker(t, map[string]string{
+		"a.example.com": `{"username":"broker-user","password":"broker-pass","expiry":1000}`,
+		"b.example.com": `{"password":"broker-pass","expiry":"1970-01-01T00:16:40Z"}`,
+		"c.example.com": `{"username":"broker-user","password":"broker-pass"}`,
+	})
+	defer srv.Close()
+
+	ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"credential.brokerendpoint": []string{srv.URL + "/credentials"},
+	})), config.EnvironmentOf(config.MapFetcher(nil)))
+
+	creds, err := ctxt.brokerCredHelper.Fill(Creds{"protocol": "https", "host": "a.example.com", "path": "repo.git"})
+	require.Nil(t, err)
+	assert.Equal(t, Creds{
+		"protocol":            "https",
+		"host":                "a.example.com",
+		"path":                "repo.git",
+		"username":            "broker-user",
+		"password":            "broker-pass",
+		"password_expiry_utc": "1000",
+	}, creds)
+
+	// an RFC 3339 expiry is given as a Unix timestamp, and the requested
+	// username is kept if the broker gives none
+	creds, err = ctxt.brokerCredHelper.Fill(Creds{"protocol": "https", "host": "b.example.com", "username": "foo"})
+	require.Nil(t, err)
+	assert.Equal(t, "foo", creds[CredsUsername])
+	assert.Equal(t, "1000", creds[CredsPasswordExpiryUTC])
+	expiry, ok := creds.PasswordExpiry()
+	assert.True(t, ok)
+	assert.Equal(t, time.Unix(1000, 0), expiry)
+
+	creds, err = ctxt.brokerCredHelper.Fill(Creds{"protocol": "https", "host": "c.example.com"})
+	require.Nil(t, err)
+	_, ok = creds[CredsPasswordExpiryUTC]
+	assert.False(t, ok)
+
+	// the next credential helper is consulted if the broker has none
+	creds, err = ctxt.brokerCredHelper.Fill(Creds{"protocol": "https", "host": "d.example.com"})
+	assert.Equal(t, credHelperNoOp, err)
+	assert.Nil(t, creds)
+
+	// as it is for URLs without a broker
+	ctxt = NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"credential.https://example.com.brokerendpoint": []string{srv.URL + "/credentials"},
+	})), config.EnvironmentOf(config.MapFetcher(nil)))
+	creds, err = ctxt.brokerCredHelper.Fill(Creds{"protocol": "https", "host": "example.org"})
+	assert.Equal(t, credHelperNoOp, err)
+	assert.Nil(t, creds)
+
+	require.Len(t, *requests, 4)
+	assert.Equal(t, brokerRequest{Action: "get", Protocol: "https", Host: "a.example.com", Path: "repo.git"}, (*requests)[0])
+	assert.Equal(t, brokerRequest{Action: "get", Protocol: "https", Host: "b.example.com", Username: "foo"}, (*requests)[1])
+}
+
+func TestBrokerCredentialHelperExpiry(t *testing.T) {
+	now := time.Unix(1000, 0)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	srv, requests := newMockBroker(t, map[string]string{
+		"example.com": `{"username":"broker-user","password":"broker-pass","expiry":1060}`,
+	})
+	defer srv.Close()
+
+	ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"credential.https://example.com.brokerendpoint": []string{srv.URL},
+	})), config.EnvironmentOf(config.MapFetcher(nil)))
+	ctxt.netrcCredHelper = nil
+	ctxt.builtinCredHelper = nil
+	u, _ := url.Parse("https://example.com/repo.git")
+
+	// credentials are cached until they expire, and then requested again
+	for i := 0; i < 2; i++ {
+		wrapper := ctxt.GetCredentialHelper(nil, u)
+		require.Nil(t, wrapper.FillCreds())
+		assert.Equal(t, "broker-pass", wrapper.Creds[CredsPassword])
+		assert.Nil(t, wrapper.CredentialHelper.Approve(wrapper.Creds))
+	}
+	assert.Len(t, *requests, 1)
+
+	now = time.Unix(1060, 0)
+	wrapper := ctxt.GetCredentialHelper(nil, u)
+	require.Nil(t, wrapper.FillCreds())
+	assert.Len(t, *requests, 2)
+}
+
+func TestBrokerCredentialHelperErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/nopassword":
+			w.Write([]byte(`{"username":"broker-user"}`))
+		case "/badexpiry":
+			w.Write([]byte(`{"password":"broker-pass","expiry":"tomorrow"}`))
+		}
+	}))
+	defer srv.Close()
+
+	for path, msg := range map[string]string{
+		"/broken":     "HTTP 500",
+		"/nopassword": `no "password"`,
+		"/badexpiry":  "invalid password_expiry_utc",
+	} {
+		ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+			"credential.https://example.com.brokerendpoint": []string{srv.URL + path},
+		})), config.EnvironmentOf(config.MapFetcher(nil)))
+		_, err := ctxt.brokerCredHelper.Fill(Creds{"protocol": "https", "host": "example.com"})
+		if assert.NotNil(t, err, path) {
+			assert.Contains(t, err.Error(), msg, path)
+		}
+	}
+}
+
+func TestBrokerCredentialHelperNotify(t *testing.T) {
+	srv, requests := newMockBroker(t, nil)
+	defer srv.Close()
+
+	creds := Creds{"protocol": "https", "host": "example.com", "username": "foo", "password": "bar"}
+
+	ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"credential.https://example.com.brokerendpoint": []string{srv.URL},
+	})), config.EnvironmentOf(config.MapFetcher(nil)))
+	assert.Nil(t, ctxt.brokerCredHelper.Approve(creds))
+	assert.Nil(t, ctxt.brokerCredHelper.Reject(creds))
+	assert.Empty(t, *requests)
+
+	ctxt = NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"credential.https://example.com.brokerendpoint": []string{srv.URL},
+		"credential.https://example.com.brokernotify":   []string{"true"},
+	})), config.EnvironmentOf(config.MapFetcher(nil)))
+	assert.Nil(t, ctxt.brokerCredHelper.Approve(creds))
+	assert.Nil(t, ctxt.brokerCredHelper.Reject(creds))
+	assert.Equal(t, []brokerRequest{
+		{Action: "approve", Protocol: "https", Host: "example.com", Username: "foo"},
+		{Action: "reject", Protocol: "https", Host: "example.com", Username: "foo"},
+	}, *requests)
+}
+
+// writeTestCertificate writes a self-signed certificate and its key, in PEM
+// form, to the given directory, returning it and the paths of both files.
+func writeTestCertificate(t *testing.T, dir, name string) (tls.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.Nil(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	require.Nil(t, ioutil.WriteFile(certFile, certPEM, 0600))
+	require.Nil(t, ioutil.WriteFile(keyFile, keyPEM, 0600))
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.Nil(t, err)
+	return cert, certFile, keyFile
+}
+
+func TestBrokerCredentialHelperMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "broker-tls")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	clientCert, certFile, keyFile := writeTestCertificate(t, dir, "client")
+	leaf, err := x509.ParseCertificate(clientCert.Certificate[0])
+	require.Nil(t, err)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(leaf)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"username":"broker-user","password":"broker-pass"}`))
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	caFile := filepath.Join(dir, "ca.crt")
+	require.Nil(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600))
+
+	what := Creds{"protocol": "https", "host": "example.com"}
+
+	// the broker is not trusted without its certificate authority, and
+	// refuses clients without a certificate
+	for _, conf := range []map[string][]string{
+		map[string][]string{
+			"credential.https://example.com.brokerendpoint": []string{srv.URL},
+		},
+		map[string][]string{
+			"credential.https://example.com.brokerendpoint":  []string{srv.URL},
+			"credential.https://example.com.brokersslcainfo": []string{caFile},
+		},
+	} {
+		ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(conf)), config.EnvironmentOf(config.MapFetcher(nil)))
+		_, err := ctxt.brokerCredHelper.Fill(what)
+		assert.NotNil(t, err)
+	}
+
+	ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"credential.https://example.com.brokerendpoint":  []string{srv.URL},
+		"credential.https://example.com.brokersslcainfo": []string{caFile},
+		"credential.https://example.com.brokersslcert":   []string{certFile},
+		"credential.https://example.com.brokersslkey":    []string{keyFile},
+	})), config.EnvironmentOf(config.MapFetcher(nil)))
+	creds, err := ctxt.brokerCredHelper.Fill(what)
+	require.Nil(t, err)
+	assert.Equal(t, "broker-pass", creds[CredsPassword])
+
+	// a missing certificate is reported
+	ctxt = NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"credential.https://example.com.brokerendpoint": []string{srv.URL},
+		"credential.https://example.com.brokersslcert":  []string{filepath.Join(dir, "missing.crt")},
+		"credential.https://example.com.brokersslkey":   []string{keyFile},
+	})), config.EnvironmentOf(config.MapFetcher(nil)))
+	_, err = ctxt.brokerCredHelper.Fill(what)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "loading client certificate")
+	}
+}
+
+func TestBrokerCredentialHelperTransport(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"username":"broker-user","password":"broker-pass"}`))
+	}))
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	what := Creds{"protocol": "https", "host": "example.com"}
+
+	// the broker is trusted by the certificate authorities of the
+	// configured transport
+	ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"credential.https://example.com.brokerendpoint": []string{srv.URL},
+	})), config.EnvironmentOf(config.MapFetcher(nil)))
+	var transportURLs []string
+	ctxt.SetTransport(func(u *url.URL) (http.RoundTripper, error) {
+		transportURLs = append(transportURLs, u.String())
+		return &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}, nil
+	})
+	for i := 0; i < 2; i++ {
+		creds, err := ctxt.brokerCredHelper.Fill(what)
+		require.Nil(t, err)
+		assert.Equal(t, "broker-pass", creds[CredsPassword])
+	}
+	assert.Equal(t, []string{srv.URL}, transportURLs)
+
+	// TLS files can only be applied to an *http.Transport
+	ctxt = NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"credential.https://example.com.brokerendpoint":  []string{srv.URL},
+		"credential.https://example.com.brokersslcainfo": []string{"ca.crt"},
+	})), config.EnvironmentOf(config.MapFetcher(nil)))
+	ctxt.SetTransport(func(u *url.URL) (http.RoundTripper, error) {
+		return &recordingTransport{}, nil
+	})
+	_, err := ctxt.brokerCredHelper.Fill(what)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "cannot apply TLS configuration")
+	}
+}
@@ -2,15 +2,29 @@ package creds
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unicode"
 
 	"github.com/git-lfs/git-lfs/config"
 	"github.com/git-lfs/git-lfs/errors"
+	"github.com/git-lfs/git-lfs/git"
+	"github.com/git-lfs/git-lfs/tools/humanize"
 	"github.com/rubyist/tracerx"
 )
 
@@ -20,6 +34,20 @@ type CredentialHelperWrapper struct {
 	Input            Creds
 	Url              *url.URL
 	Creds            Creds
+
+	// Transform, if non-nil, is applied to the filled Creds before they
+	// are used to authenticate a request. The untransformed Creds are
+	// the ones approved or rejected.
+	Transform func(Creds) (Creds, error)
+}
+
+// UsableCreds returns the filled Creds with the wrapper's Transform, if any,
+// applied, for use in authenticating a request.
+func (credWrapper *CredentialHelperWrapper) UsableCreds() (Creds, error) {
+	if credWrapper.Transform == nil || credWrapper.Creds == nil {
+		return credWrapper.Creds, nil
+	}
+	return credWrapper.Transform(credWrapper.Creds)
 }
 
 // CredentialHelper is an interface used by the lfsapi Client to interact with
@@ -31,369 +59,3186 @@ type CredentialHelper interface {
 	Approve(Creds) error
 }
 
+// ProtocolCredentialHelper is an optional interface implemented by a
+// CredentialHelper that only supports some protocols, such as "https". A
+// CredentialHelper that does not implement it is assumed to support every
+// protocol.
+type ProtocolCredentialHelper interface {
+	SupportsProtocol(proto string) bool
+}
+
+// NamedCredentialHelper is an optional interface implemented by a
+// CredentialHelper to give itself a short, stable name, such as "netrc", for
+// use in trace output and error messages.
+type NamedCredentialHelper interface {
+	Name() string
+}
+
+// credentialHelperName returns the short name of the given CredentialHelper,
+// or its type if it has none.
+func credentialHelperName(h CredentialHelper) string {
+	if nh, ok := h.(NamedCredentialHelper); ok {
+		return nh.Name()
+	}
+	return fmt.Sprintf("%T", h)
+}
+
+// supportsProtocol returns whether the given CredentialHelper supports the
+// given protocol.
+func supportsProtocol(h CredentialHelper, proto string) bool {
+	if ph, ok := h.(ProtocolCredentialHelper); ok {
+		return ph.SupportsProtocol(proto)
+	}
+	return true
+}
+
 func (credWrapper *CredentialHelperWrapper) FillCreds() error {
 	creds, err := credWrapper.CredentialHelper.Fill(credWrapper.Input)
 	if creds == nil || len(creds) < 1 {
-		errmsg := fmt.Sprintf("Git credentials for %s not found", credWrapper.Url)
+		errmsg := fmt.Sprintf("Git credentials for %s not found", SanitizeURL(credWrapper.Url))
 		if err != nil {
 			errmsg = fmt.Sprintf("%s:\n%s", errmsg, err.Error())
 		} else {
 			errmsg = fmt.Sprintf("%s.", errmsg)
 		}
 		err = errors.New(errmsg)
+	} else if _, ok := creds[CredsURL]; ok {
+		creds = credsFromURLField(creds)
 	}
 	credWrapper.Creds = creds
 	return err
 }
 
+// ValidateCreds returns an error naming the field missing from the given
+// filled Creds, if any, for the authentication scheme with which they would be
+// sent, so that a malformed Authorization header is never sent. HTTP Basic
+// authentication, which is used if there is no "authtype", requires both a
+// username and a password, and any other scheme requires a "credential" or a
+// password.
+func ValidateCreds(c Creds) error {
+	authtype := c[CredsAuthtype]
+	if len(authtype) > 0 && len(c[CredsCredential]) > 0 {
+		return nil
+	}
+
+	if len(authtype) == 0 || strings.EqualFold(authtype, "Basic") {
+		for _, key := range []string{CredsUsername, CredsPassword} {
+			if len(c[key]) == 0 {
+				return errors.Errorf("credentials for %s://%s have no %s, which Basic authentication requires",
+					c[CredsProtocol], c[CredsHost], key)
+			}
+		}
+		return nil
+	}
+
+	if len(c[CredsPassword]) == 0 {
+		return errors.Errorf("credentials for %s://%s have no credential or password, which %s authentication requires",
+			c[CredsProtocol], c[CredsHost], authtype)
+	}
+	return nil
+}
+
+// EffectiveURL returns the URL to which requests authenticated with the
+// filled Creds should be sent. This is the wrapper's Url, unless a credential
+// helper returned a "url", in which case the protocol, host and path derived
+// from it replace those of the Url.
+func (credWrapper *CredentialHelperWrapper) EffectiveURL() *url.URL {
+	if credWrapper.Url == nil || len(credWrapper.Creds[CredsURL]) == 0 {
+		return credWrapper.Url
+	}
+
+	u := *credWrapper.Url
+	u.Scheme = credWrapper.Creds[CredsProtocol]
+	u.Host = credWrapper.Creds[CredsHost]
+	if path, ok := credWrapper.Creds[CredsPath]; ok {
+		u.Path = "/" + strings.TrimPrefix(path, "/")
+		u.RawPath = ""
+	}
+	return &u
+}
+
+// credsFromURLField returns the given filled Creds with their protocol, host
+// and path, and their username and password if given, replaced by those of
+// their "url", as Git does when a credential helper returns one. If the "url"
+// is invalid, it is removed, and the Creds are otherwise unchanged.
+func credsFromURLField(filled Creds) Creds {
+	merged := make(Creds, len(filled))
+	for k, v := range filled {
+		merged[k] = v
+	}
+
+	u, err := url.Parse(filled[CredsURL])
+	if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+		tracerx.Printf("creds: ignoring invalid url returned by credential helper")
+		delete(merged, CredsURL)
+		return merged
+	}
+
+	tracerx.Printf("creds: credential helper redirected to %s", SanitizeURL(u))
+	merged[CredsProtocol] = u.Scheme
+	merged[CredsHost] = u.Host
+	if path := strings.TrimPrefix(u.Path, "/"); len(path) > 0 {
+		merged[CredsPath] = path
+	} else {
+		delete(merged, CredsPath)
+	}
+	if u.User != nil {
+		if username := u.User.Username(); len(username) > 0 {
+			merged[CredsUsername] = username
+		}
+		if password, ok := u.User.Password(); ok {
+			merged[CredsPassword] = password
+		}
+	}
+	return merged
+}
+
 // Creds represents a set of key/value pairs that are passed to 'git credential'
 // as input.
 type Creds map[string]string
 
-func bufferCreds(c Creds) *bytes.Buffer {
-	buf := new(bytes.Buffer)
+// Well-known Creds keys, as described in the "INPUT/OUTPUT FORMAT" section of
+// git-credential(1).
+const (
+	CredsProtocol   = "protocol"
+	CredsHost       = "host"
+	CredsUsername   = "username"
+	CredsPassword   = "password"
+	CredsPath       = "path"
+	CredsAuthtype   = "authtype"
+	CredsCredential = "credential"
+
+	// CredsURL may be returned by a credential helper in place of, or
+	// in addition to, the protocol, host and path, which are derived from
+	// it, redirecting requests to it.
+	CredsURL = "url"
+
+	CredsPasswordExpiryUTC = "password_expiry_utc"
+	CredsOAuthRefreshToken = "oauth_refresh_token"
+
+	// CredsState holds the "state[]" values a helper emitted when filling,
+	// which must be given back to it when approving or rejecting. Since
+	// the key may be repeated, its values are joined with newlines, which
+	// cannot otherwise appear in a value.
+	CredsState = "state[]"
+)
+
+// Non-standard Creds keys, sent to credential helpers as hints when
+// "credential.<url>.sendHints" is enabled.
+const (
+	CredsOperation = "operation"
+	CredsRef       = "ref"
+)
+
+// CredsScope distinguishes credentials for an HTTP proxy, whose value is
+// CredsScopeProxy, from those for the origin server, which have none. It is
+// kept with filled credentials, so that the two are cached under different
+// keys even if the proxy and the origin share a host. Git ignores this key.
+const (
+	CredsScope      = "scope"
+	CredsScopeProxy = "proxy"
+)
+
+// CredsFromURL returns the Creds with which to ask a credential helper for
+// credentials for the given URL: its protocol, host and username, if any, and,
+// if useHttpPath is true, its path.
+func CredsFromURL(u *url.URL, useHttpPath bool) Creds {
+	creds := Creds{CredsProtocol: u.Scheme, CredsHost: u.Host}
+	if u.User != nil && u.User.Username() != "" {
+		creds[CredsUsername] = u.User.Username()
+	}
+	if useHttpPath {
+		creds[CredsPath] = strings.TrimPrefix(u.Path, "/")
+	}
+	return creds
+}
+
+// splitIPv6Host splits the given "host" field, which may have a port, into its
+// IPv6 literal address and port, if it is an IPv6 literal. As in a URL, the
+// address is normally enclosed in brackets, but a bare address, such as one
+// given by "credential.<url>.sameAs", is accepted too, and has no port.
+func splitIPv6Host(host string) (addr, port string, ok bool) {
+	addr = host
+	if strings.HasPrefix(host, "[") {
+		end := strings.Index(host, "]")
+		if end < 0 {
+			return "", "", false
+		}
+		addr = host[1:end]
+		if rest := host[end+1:]; len(rest) > 0 {
+			if rest[0] != ':' {
+				return "", "", false
+			}
+			port = rest[1:]
+		}
+	}
+
+	if !strings.Contains(addr, ":") || net.ParseIP(withoutZone(addr)) == nil {
+		return "", "", false
+	}
+	return addr, port, true
+}
+
+// withoutZone returns the given IPv6 address without its zone, if any, such as
+// "%eth0".
+func withoutZone(addr string) string {
+	if i := strings.Index(addr, "%"); i >= 0 {
+		return addr[:i]
+	}
+	return addr
+}
+
+// joinIPv6Host returns the "host" field for the given IPv6 address and port, in
+// the form Git uses for a URL, with the address enclosed in brackets.
+func joinIPv6Host(addr, port string) string {
+	host := "[" + addr + "]"
+	if len(port) > 0 {
+		host += ":" + port
+	}
+	return host
+}
+
+// normalizeHost returns the given "host" field with an IPv6 literal address, if
+// any, enclosed in brackets, as 'git credential' gives it to credential
+// helpers. Other hosts are returned unchanged.
+func normalizeHost(host string) string {
+	if addr, port, ok := splitIPv6Host(host); ok {
+		return joinIPv6Host(addr, port)
+	}
+	return host
+}
+
+// hostKey returns the given "host" field in the form used in cache keys. An
+// IPv6 literal address is enclosed in brackets and canonicalized, so that, for
+// example, "::1", "[::1]" and "[0:0::1]" have the same key. Other hosts are
+// returned unchanged.
+func hostKey(host string) string {
+	addr, port, ok := splitIPv6Host(host)
+	if !ok {
+		return host
+	}
+	zone := addr[len(withoutZone(addr)):]
+	return joinIPv6Host(net.ParseIP(withoutZone(addr)).String()+zone, port)
+}
+
+// timeNow returns the current time. It is a variable so that tests may control
+// the time against which credential expiry is checked.
+var timeNow = time.Now
+
+// Expired returns whether the "password_expiry_utc" field, a Unix timestamp,
+// lies at or before the given time. Creds without a valid expiry never expire.
+func (c Creds) Expired(now time.Time) bool {
+	expiry, ok := c.PasswordExpiry()
+	if !ok {
+		return false
+	}
+	return !now.Before(expiry)
+}
+
+// OAuthRefreshToken returns the "oauth_refresh_token" filled with the Creds, if
+// any, with which an OAuth access token may be refreshed.
+func (c Creds) OAuthRefreshToken() string {
+	return c[CredsOAuthRefreshToken]
+}
+
+// PasswordExpiry returns the time given by the "password_expiry_utc" field, and
+// whether it is present and valid.
+func (c Creds) PasswordExpiry() (time.Time, bool) {
+	expiry, err := parsePasswordExpiry(c[CredsPasswordExpiryUTC])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return expiry, true
+}
+
+// parsePasswordExpiry parses the value of a "password_expiry_utc" field. Git
+// gives it as a Unix timestamp in seconds, but some third-party credential
+// helpers return an RFC 3339 time, such as "2024-01-02T15:04:05Z", so either
+// is accepted.
+func parsePasswordExpiry(value string) (time.Time, error) {
+	if expiry, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(expiry, 0), nil
+	}
+	if expiry, err := time.Parse(time.RFC3339, value); err == nil {
+		return expiry, nil
+	}
+	return time.Time{}, errors.Errorf("invalid %s %q: expected a Unix timestamp or an RFC 3339 time",
+		CredsPasswordExpiryUTC, value)
+}
+
+// normalizePasswordExpiry rewrites the "password_expiry_utc" field of the given
+// Creds, if it is an RFC 3339 time, as the Unix timestamp Git expects, so that
+// it is understood when the credentials are passed on to 'git credential'.
+// Invalid values are left unchanged, and never expire.
+func normalizePasswordExpiry(creds Creds) {
+	value, ok := creds[CredsPasswordExpiryUTC]
+	if !ok {
+		return
+	}
+
+	expiry, err := parsePasswordExpiry(value)
+	if err != nil {
+		tracerx.Printf("creds: %s", err)
+		return
+	}
+	creds[CredsPasswordExpiryUTC] = strconv.FormatInt(expiry.Unix(), 10)
+}
+
+// Get returns the value stored under the given key, or the empty string if
+// there is none.
+func (c Creds) Get(key string) string {
+	return c[key]
+}
+
+// Set stores the given value under the given key. The Creds must have been
+// initialized.
+func (c Creds) Set(key, value string) {
+	c[key] = value
+}
+
+// sensitiveCredsKeys are the Creds keys whose values are secrets, and are
+// cleared by Zeroize.
+var sensitiveCredsKeys = []string{CredsPassword, CredsCredential, CredsOAuthRefreshToken}
+
+// Zeroize removes the secrets, such as the password, from the Creds, once they
+// are no longer needed, so that they are not kept alive by it. This is best
+// effort: since Go strings are immutable, the memory holding them cannot be
+// overwritten, and is only reclaimed once no other reference to it remains.
+// The credential cache holds its own copy of any Creds it stores, so is not
+// affected.
+func (c Creds) Zeroize() {
+	for _, key := range sensitiveCredsKeys {
+		delete(c, key)
+	}
+}
 
+// copyCreds returns a copy of the given Creds, which may be modified without
+// affecting them.
+func copyCreds(c Creds) Creds {
+	if c == nil {
+		return nil
+	}
+	copied := make(Creds, len(c))
 	for k, v := range c {
-		buf.Write([]byte(k))
-		buf.Write([]byte("="))
-		buf.Write([]byte(v))
+		copied[k] = v
+	}
+	return copied
+}
+
+// zeroBytes overwrites the given bytes, such as the output of a credential
+// helper once parsed, so that any secrets in them do not linger in memory.
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// redactSecrets returns the given message, such as that of an error from a
+// credential helper, with any of the secrets in the given Creds replaced, so
+// that a malformed credential helper which echoes its input cannot cause them
+// to be shown or logged.
+func redactSecrets(msg string, creds Creds) string {
+	for _, key := range sensitiveCredsKeys {
+		if secret := creds[key]; len(secret) > 0 {
+			msg = strings.Replace(msg, secret, "xxxxx", -1)
+		}
+	}
+	return msg
+}
+
+// orderedCredsKeys are the Creds keys which bufferCreds writes first, in
+// order. Any others follow, sorted.
+var orderedCredsKeys = []string{CredsProtocol, CredsHost, CredsPath, CredsUsername, CredsPassword}
+
+// bufferCreds returns the given Creds in the format read by 'git credential',
+// preceded by a "capability[]" line for each of the given capabilities. The
+// keys are always written in the same order, as given by orderedCredsKeys, so
+// that the output is reproducible.
+func bufferCreds(c Creds, capabilities ...string) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+
+	for _, capability := range capabilities {
+		buf.Write([]byte("capability[]="))
+		buf.Write([]byte(capability))
 		buf.Write([]byte("\n"))
 	}
 
+	keys := make([]string, 0, len(c))
+	ordered := make(map[string]bool, len(orderedCredsKeys))
+	for _, k := range orderedCredsKeys {
+		ordered[k] = true
+		if _, ok := c[k]; ok {
+			keys = append(keys, k)
+		}
+	}
+	rest := make([]string, 0, len(c))
+	for k := range c {
+		if !ordered[k] {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	keys = append(keys, rest...)
+
+	for _, k := range keys {
+		v := c[k]
+		values := []string{v}
+		if isMultiValuedKey(k) {
+			values = strings.Split(v, "\n")
+		}
+		for _, v := range values {
+			buf.Write([]byte(k))
+			buf.Write([]byte("="))
+			buf.Write([]byte(v))
+			buf.Write([]byte("\n"))
+		}
+	}
+
 	return buf
 }
 
+// knownCredsKeys maps the lowercase form of each well-known Creds key to the
+// key itself, so that keys emitted with other capitalization by third-party
+// credential helpers, such as "Username", are recognized.
+var knownCredsKeys = map[string]string{
+	CredsProtocol:          CredsProtocol,
+	CredsHost:              CredsHost,
+	CredsUsername:          CredsUsername,
+	CredsPassword:          CredsPassword,
+	CredsPath:              CredsPath,
+	CredsAuthtype:          CredsAuthtype,
+	CredsCredential:        CredsCredential,
+	CredsURL:               CredsURL,
+	CredsPasswordExpiryUTC: CredsPasswordExpiryUTC,
+	CredsOAuthRefreshToken: CredsOAuthRefreshToken,
+	CredsState:             CredsState,
+}
+
+// canonicalCredsKey returns the given key as it is spelled in the credential
+// helper protocol, if it is a well-known key in any capitalization, or
+// unchanged otherwise.
+func canonicalCredsKey(key string) string {
+	if canonical, ok := knownCredsKeys[strings.ToLower(key)]; ok {
+		return canonical
+	}
+	return key
+}
+
+// isMultiValuedKey returns whether the given Creds key, such as "state[]", may
+// be given more than once, in which case its values are joined with newlines.
+func isMultiValuedKey(key string) bool {
+	return strings.HasSuffix(key, "[]")
+}
+
 type CredentialHelperContext struct {
 	netrcCredHelper   *netrcCredentialHelper
 	commandCredHelper *commandCredentialHelper
 	askpassCredHelper *AskPassCredentialHelper
 	cachingCredHelper *credentialCacher
+	builtinCredHelper CredentialHelper
+
+	// askpassSource is the environment variable or configuration key
+	// which set the askpassCredHelper's program, if any.
+	askpassSource string
+
+	// negotiateCredHelper, if non-nil, is consulted first when the server
+	// challenges with "Negotiate", as "lfs.negotiatecredentialhelper" is
+	// enabled.
+	negotiateCredHelper CredentialHelper
+
+	// stdinCredHelper, if non-nil, is the only credential helper used,
+	// as "lfs.credentialfromstdin" is enabled.
+	stdinCredHelper *stdinCredentialHelper
+
+	// refreshCredHelper, if non-nil, refreshes cached OAuth access tokens
+	// for URLs with a "credential.<url>.tokenEndpoint", as caching is
+	// enabled.
+	refreshCredHelper *oauthRefreshCredentialHelper
+
+	// vaultCredHelper, if non-nil, reads credentials from HashiCorp Vault
+	// for URLs with a "credential.<url>.vaultPath", as VAULT_ADDR is set.
+	vaultCredHelper *vaultCredentialHelper
+
+	// terminalCredHelper, if non-nil, prompts for credentials on the
+	// controlling terminal when nothing else filled any, as
+	// "lfs.terminalprompt" is enabled.
+	terminalCredHelper *TerminalCredentialHelper
+
+	// brokerCredHelper requests credentials from an HTTP token broker for
+	// URLs with a "credential.<url>.brokerEndpoint".
+	brokerCredHelper *BrokerCredentialHelper
+
+	// processCredHelper, if non-nil, runs the credential helper program
+	// named by "lfs.credentialhelperprogram" directly.
+	processCredHelper *ProcessCredentialHelper
+
+	// builtinCredHelperAuto is true if the builtinCredHelper was chosen
+	// by default rather than configured, in which case it is only used
+	// for URLs without a "credential.helper".
+	builtinCredHelperAuto bool
+
+	// insteadOf maps the base of each `url.<base>.insteadOf` rule to the
+	// URL prefix it replaces. It is only populated if
+	// "lfs.credentialinsteadof" is enabled.
+	insteadOf map[string]string
+
+	// credentialGroups maps the key of each host listed in a
+	// "credentialGroup.<name>.host" entry, as given by groupHostKey, to
+	// its group.
+	credentialGroups map[string]credentialGroup
+
+	// readOnly is true if approvals and rejections should not be written
+	// back to external credential stores.
+	readOnly bool
+
+	// strictMatch is true if filled credentials for a protocol or host
+	// other than the requested one should be discarded.
+	strictMatch bool
+
+	// mergePartial is true if credential helpers after one which filled
+	// incomplete credentials should be consulted, and their results
+	// merged.
+	mergePartial bool
+
+	// handoff is true if the keys of cached credentials should be handed
+	// off to child processes.
+	handoff bool
+
+	// allowInsecure is false if credentials must not be filled for
+	// protocols which send them in plaintext.
+	allowInsecure bool
+
+	// recursionErr is non-nil if this process was started by a credential
+	// helper which Git LFS itself invoked, in which case no credentials
+	// are filled.
+	recursionErr error
+
+	// interactive is the value of "credential.interactive". If it is
+	// interactiveNever, no credential helper which prompts is used.
+	interactive credentialInteractive
+
+	// rejectedUsernames maps the cache key of each rejected credential
+	// to its username, so that the username is kept when the credential
+	// is filled again, and only the password is asked for.
+	rejectedUsernames map[string]string
+	rejectedMu        sync.Mutex
+
+	// rejections maps the key of each host, as given by attemptKey, to
+	// the number of times credentials for it were rejected since they
+	// were last approved, and maxAttempts is the number of rejections
+	// after which no more credentials are filled for it, or 0 if there is
+	// no limit. Both are guarded by rejectedMu.
+	rejections  map[string]int
+	maxAttempts int
+
+	// augmentInput, if non-nil, is given the input of every fill, and
+	// returns it with any fields the embedding program wishes to add.
+	augmentInput func(Creds) Creds
+
+	// transport, if non-nil, returns the http.RoundTripper for requests
+	// which credential helpers make to the given URL, as set by
+	// SetTransport.
+	transport func(*url.URL) (http.RoundTripper, error)
+
+	// approveEvent and rejectEvent, if non-nil, are given a
+	// CredentialEvent for each approval and rejection, as set by
+	// OnApprove and OnReject.
+	approveEvent func(CredentialEvent)
+	rejectEvent  func(CredentialEvent)
+
+	// pendingSets holds each PendingApprovals into which approvals have
+	// been deferred and which has not yet been committed or discarded, so
+	// that rejected credentials are discarded from all of them.
+	pendingSets map[*PendingApprovals]bool
+	pendingMu   sync.Mutex
+
+	// envHelper is the credential helper given by the
+	// GIT_LFS_CREDENTIAL_HELPER environment variable, if any, which 'git
+	// credential' uses in place of any "credential.helper" entries.
+	envHelper string
+
+	// genericHelpers holds the "credential.helper" entries which apply to
+	// every URL.
+	genericHelpers []string
+
+	gitEnv config.Environment
 
 	urlConfig *config.URLConfig
 }
 
 func NewCredentialHelperContext(gitEnv config.Environment, osEnv config.Environment) *CredentialHelperContext {
-	c := &CredentialHelperContext{urlConfig: config.NewURLConfig(gitEnv)}
+	c := &CredentialHelperContext{
+		urlConfig:         config.NewURLConfig(gitEnv),
+		rejectedUsernames: make(map[string]string),
+		rejections:        make(map[string]int),
+		pendingSets:       make(map[*PendingApprovals]bool),
+		maxAttempts:       gitEnv.Int("lfs.maxcredentialattempts", 3),
+		genericHelpers:    gitEnv.GetAll("credential.helper"),
+		gitEnv:            gitEnv,
+	}
 
 	c.netrcCredHelper = newNetrcCredentialHelper(osEnv)
+	c.envHelper, _ = osEnv.Get("GIT_LFS_CREDENTIAL_HELPER")
 
 	askpass, ok := osEnv.Get("GIT_ASKPASS")
+	askpassSource := "GIT_ASKPASS"
 	if !ok {
 		askpass, ok = gitEnv.Get("core.askpass")
+		askpassSource = "core.askpass"
 	}
-	if !ok {
+	if !ok && gitEnv.Bool("lfs.usesshaskpass", true) {
 		askpass, _ = osEnv.Get("SSH_ASKPASS")
+		askpassSource = "SSH_ASKPASS"
 	}
 	if len(askpass) > 0 {
 		c.askpassCredHelper = &AskPassCredentialHelper{
 			Program: askpass,
+			Timeout: time.Duration(gitEnv.Int("lfs.askpasstimeout", 0)) * time.Second,
 		}
+		c.askpassCredHelper.PromptTemplate, _ = gitEnv.Get("lfs.credentialprompttemplate")
+		c.askpassCredHelper.PasswordOnly = gitEnv.Bool("lfs.askpasspasswordonly", false)
+		c.askpassSource = askpassSource
 	}
 
 	cacheCreds := gitEnv.Bool("lfs.cachecredentials", true)
 	if cacheCreds {
 		c.cachingCredHelper = NewCredentialCacher()
+		c.cachingCredHelper.maxEntries = gitEnv.Int("lfs.credentialcachesize", 0)
+		c.cachingCredHelper.cacheBearerWithoutExpiry = gitEnv.Bool("lfs.cachebearerwithoutexpiry", true)
+		c.cachingCredHelper.pathFallback = gitEnv.Bool("lfs.credentialpathfallback", false)
+		c.cachingCredHelper.approveHostScope = gitEnv.Bool("lfs.approvehostscope", false)
+		if value, ok := gitEnv.Get("lfs.credentialcachettl"); ok {
+			if ttl, err := parseSeconds(value); err == nil {
+				c.cachingCredHelper.ttl = ttl
+			} else {
+				tracerx.Printf("creds: invalid lfs.credentialcachettl %q: %s", value, err)
+			}
+		}
+		if value, ok := gitEnv.Get("lfs.credentialexpiryjitter"); ok {
+			if jitter, err := parseSeconds(value); err == nil {
+				c.cachingCredHelper.jitter = jitter
+			} else {
+				tracerx.Printf("creds: invalid lfs.credentialexpiryjitter %q: %s", value, err)
+			}
+		}
+		c.refreshCredHelper = newOAuthRefreshCredentialHelper(gitEnv, c.urlConfig, c.cachingCredHelper, c.httpClient)
+	}
+
+	if name, ok := gitEnv.Get("lfs.credentialhelper"); ok {
+		c.builtinCredHelper = newBuiltinCredentialHelper(name)
+	} else if len(defaultBuiltinCredentialHelper) > 0 {
+		c.builtinCredHelper = newBuiltinCredentialHelper(defaultBuiltinCredentialHelper)
+		c.builtinCredHelperAuto = true
+	}
+
+	if gitEnv.Bool("lfs.negotiatecredentialhelper", false) {
+		c.negotiateCredHelper = newNegotiateCredentialHelper()
+		if c.negotiateCredHelper == nil {
+			tracerx.Printf("creds: negotiate credential helper is not available")
+		}
+	}
+
+	c.vaultCredHelper = newVaultCredentialHelper(osEnv, c.urlConfig, c.httpClient)
+	c.brokerCredHelper = newBrokerCredentialHelper(c.urlConfig, c.httpClient)
+
+	if program, ok := gitEnv.Get("lfs.credentialhelperprogram"); ok && len(program) > 0 {
+		c.processCredHelper = &ProcessCredentialHelper{Program: program}
+	}
+
+	if gitEnv.Bool("lfs.terminalprompt", false) {
+		c.terminalCredHelper = &TerminalCredentialHelper{
+			SkipPrompt: !osEnv.Bool("GIT_TERMINAL_PROMPT", true),
+		}
 	}
 
+	if gitEnv.Bool("lfs.credentialfromstdin", false) {
+		c.stdinCredHelper = defaultStdinCredentialHelper
+	}
+
+	c.readOnly = gitEnv.Bool("lfs.credentialsreadonly", false)
+	c.allowInsecure = gitEnv.Bool("lfs.credentialallowinsecure", true)
+	c.strictMatch = gitEnv.Bool("lfs.credentialsstrictmatch", false)
+	c.mergePartial = gitEnv.Bool("lfs.credentialmergepartial", false)
+
+	if gitEnv.Bool("lfs.credentialinsteadof", false) {
+		c.insteadOf = insteadOfRules(gitEnv)
+	}
+	c.credentialGroups = credentialGroupRules(gitEnv)
+
 	c.commandCredHelper = &commandCredentialHelper{
-		SkipPrompt: osEnv.Bool("GIT_TERMINAL_PROMPT", false),
+		SkipPrompt:         osEnv.Bool("GIT_TERMINAL_PROMPT", false),
+		LockedPattern:      defaultLockedPattern,
+		UnreachablePattern: defaultUnreachablePattern,
+		maxOutput:          defaultCredentialHelperMaxOutput,
+	}
+	if value, ok := gitEnv.Get("lfs.credentialhelpermaxoutput"); ok {
+		if max, err := humanize.ParseBytes(value); err == nil {
+			c.commandCredHelper.maxOutput = int64(max)
+		} else {
+			tracerx.Printf("creds: invalid lfs.credentialhelpermaxoutput %q: %s", value, err)
+		}
+	}
+	if _, ok := osEnv.Get("GIT_TERMINAL_PROMPT"); !ok {
+		c.commandCredHelper.hasTerminal = hasTerminal
+	}
+	if value, ok := gitEnv.Get("credential.interactive"); ok {
+		interactive, valid := parseCredentialInteractive(value)
+		if !valid {
+			tracerx.Printf("creds: invalid credential.interactive %q, using %q", value, "auto")
+		}
+		c.interactive = interactive
+		c.commandCredHelper.interactive = interactive
+	}
+	if pattern, ok := gitEnv.Get("lfs.credentiallockedpattern"); ok {
+		if re, err := regexp.Compile(pattern); err == nil {
+			c.commandCredHelper.LockedPattern = re
+		} else {
+			tracerx.Printf("creds: invalid lfs.credentiallockedpattern %q: %s", pattern, err)
+		}
+	}
+	if entries := gitEnv.GetAll("lfs.credentialhelperenv"); len(entries) > 0 {
+		allowed := parseEnvAllowlist(entries)
+		c.commandCredHelper.allowedEnv = allowed
+		if c.askpassCredHelper != nil {
+			c.askpassCredHelper.allowedEnv = allowed
+		}
+		if c.processCredHelper != nil {
+			c.processCredHelper.allowedEnv = allowed
+		}
+	}
+
+	if _, ok := osEnv.Get(credentialRecursionEnv); ok {
+		c.recursionErr = errors.Errorf("credential helper recursion detected: a credential helper run by Git LFS ran Git LFS again; check %q", "credential.helper")
+		tracerx.Printf("creds: %s", c.recursionErr)
+	}
+
+	c.handoff = gitEnv.Bool("lfs.credentialhandoff", false)
+	if c.handoff {
+		if value, ok := osEnv.Get(CredentialHandoffEnv); ok {
+			c.commandCredHelper.noPrompt = decodeCredentialHandoff(value)
+		}
 	}
 
 	return c
 }
 
-// getCredentialHelper parses a 'credsConfig' from the git and OS environments,
-// returning the appropriate CredentialHelper to authenticate requests with.
-//
-// It returns an error if any configuration was invalid, or otherwise
-// un-useable.
-func (ctxt *CredentialHelperContext) GetCredentialHelper(helper CredentialHelper, u *url.URL) CredentialHelperWrapper {
-	rawurl := fmt.Sprintf("%s://%s%s", u.Scheme, u.Host, u.Path)
-	input := Creds{"protocol": u.Scheme, "host": u.Host}
-	if u.User != nil && u.User.Username() != "" {
-		input["username"] = u.User.Username()
-	}
-	if u.Scheme == "cert" || ctxt.urlConfig.Bool("credential", rawurl, "usehttppath", false) {
-		input["path"] = strings.TrimPrefix(u.Path, "/")
+// insteadOfRules returns a map of each `url.<base>.insteadOf` base to the
+// first URL prefix it is configured to replace.
+func insteadOfRules(gitEnv config.Environment) map[string]string {
+	const prefix, suffix = "url.", ".insteadof"
+
+	rules := make(map[string]string)
+	for key, values := range gitEnv.All() {
+		if len(values) == 0 || !strings.HasPrefix(key, prefix) || !strings.HasSuffix(key, suffix) {
+			continue
+		}
+		rules[key[len(prefix):len(key)-len(suffix)]] = values[0]
 	}
+	return rules
+}
 
-	if helper != nil {
-		return CredentialHelperWrapper{CredentialHelper: helper, Input: input, Url: u}
+// unaliasURL returns the URL that the given URL was rewritten from by a
+// `url.<base>.insteadOf` rule, so that credentials are looked up under the
+// URL the user originally configured. If multiple rules match, the longest
+// base is used. If none match, or the original URL is not absolute, the given
+// URL is returned unchanged.
+func (ctxt *CredentialHelperContext) unaliasURL(u *url.URL) *url.URL {
+	rawurl := u.String()
+
+	var longest string
+	for base := range ctxt.insteadOf {
+		if strings.HasPrefix(rawurl, base) && len(base) > len(longest) {
+			longest = base
+		}
+	}
+	if len(longest) == 0 {
+		return u
 	}
 
-	helpers := make([]CredentialHelper, 0, 4)
-	if ctxt.netrcCredHelper != nil {
-		helpers = append(helpers, ctxt.netrcCredHelper)
+	original, err := url.Parse(ctxt.insteadOf[longest] + rawurl[len(longest):])
+	if err != nil || len(original.Scheme) == 0 || len(original.Host) == 0 {
+		return u
 	}
-	if ctxt.cachingCredHelper != nil {
-		helpers = append(helpers, ctxt.cachingCredHelper)
+
+	tracerx.Printf("creds: using credentials for %s instead of %s", SanitizeURL(original), SanitizeURL(u))
+	return original
+}
+
+// credentialGroup is a set of hosts, listed by "credentialGroup.<name>.host"
+// entries, which share one set of credentials, such as the many hostnames of a
+// mirror or CDN backed by one identity.
+type credentialGroup struct {
+	// name is the <name> of the group's entries.
+	name string
+	// host is the first host listed, under which the group's credentials
+	// are filled, cached, and configured.
+	host string
+}
+
+// credentialGroupRules returns a map of the key of each host listed by a
+// "credentialGroup.<name>.host" entry, which may be either a bare host or a
+// URL, to its group. A host listed in more than one group belongs to the
+// first by name.
+func credentialGroupRules(gitEnv config.Environment) map[string]credentialGroup {
+	const prefix, suffix = "credentialgroup.", ".host"
+
+	all := gitEnv.All()
+	names := make([]string, 0)
+	for key, values := range all {
+		if len(values) == 0 || !strings.HasPrefix(key, prefix) || !strings.HasSuffix(key, suffix) {
+			continue
+		}
+		names = append(names, key[len(prefix):len(key)-len(suffix)])
 	}
-	if ctxt.askpassCredHelper != nil {
-		helper, _ := ctxt.urlConfig.Get("credential", rawurl, "helper")
-		if len(helper) == 0 {
-			helpers = append(helpers, ctxt.askpassCredHelper)
+	sort.Strings(names)
+
+	groups := make(map[string]credentialGroup)
+	for _, name := range names {
+		hosts := all[prefix+name+suffix]
+		group := credentialGroup{name: name, host: normalizeHost(sameAsHost(hosts[0]))}
+		for _, host := range hosts {
+			key := groupHostKey(sameAsHost(host))
+			if existing, ok := groups[key]; ok {
+				tracerx.Printf("creds: %s is already in credential group %q, not %q", host, existing.name, name)
+				continue
+			}
+			groups[key] = group
 		}
 	}
-	return CredentialHelperWrapper{CredentialHelper: NewCredentialHelpers(append(helpers, ctxt.commandCredHelper)), Input: input, Url: u}
+	return groups
 }
 
-// AskPassCredentialHelper implements the CredentialHelper type for GIT_ASKPASS
-// and 'core.askpass' configuration values.
-type AskPassCredentialHelper struct {
-	// Program is the executable program's absolute or relative name.
-	Program string
+// groupHostKey returns the key under which the given host is looked up in
+// credential groups, so that hosts differing only in case, or in the form of
+// an IPv6 literal address, are the same.
+func groupHostKey(host string) string {
+	return strings.ToLower(hostKey(host))
 }
 
-type credValueType int
+// groupURL returns the given URL with its host replaced by that under which
+// the credentials of its credential group are filled, if it belongs to one,
+// so that every host in the group shares the credentials cached for it, and
+// the "credential.<url>" configuration of that host applies to them all.
+func (ctxt *CredentialHelperContext) groupURL(u *url.URL) *url.URL {
+	group, ok := ctxt.credentialGroups[groupHostKey(u.Host)]
+	if !ok || groupHostKey(group.host) == groupHostKey(u.Host) {
+		return u
+	}
 
-const (
-	credValueTypeUnknown credValueType = iota
-	credValueTypeUsername
-	credValueTypePassword
-)
+	grouped := *u
+	grouped.Host = group.host
+	tracerx.Printf("creds: using credentials of group %q for %s", group.name, SanitizeURL(u))
+	return &grouped
+}
 
-// Fill implements fill by running the ASKPASS program and returning its output
-// as a password encoded in the Creds type given the key "password".
-//
-// It accepts the password as coming from the program's stdout, as when invoked
-// with the given arguments (see (*AskPassCredentialHelper).args() below)./
-//
-// If there was an error running the command, it is returned instead of a set of
-// filled credentials.
-//
-// The ASKPASS program is only queried if a credential was not already
-// provided, i.e. through the git URL
-func (a *AskPassCredentialHelper) Fill(what Creds) (Creds, error) {
-	u := &url.URL{
-		Scheme: what["protocol"],
-		Host:   what["host"],
-		Path:   what["path"],
+// newBuiltinCredentialHelper returns the built-in credential helper with the
+// given name, or nil if there is no such helper on this platform.
+func newBuiltinCredentialHelper(name string) CredentialHelper {
+	var helper CredentialHelper
+	switch name {
+	case "wincred":
+		helper = newWinCredCredentialHelper()
+	case "osxkeychain":
+		helper = newKeychainCredentialHelper()
 	}
 
-	creds := make(Creds)
+	if helper == nil && len(name) > 0 {
+		tracerx.Printf("creds: built-in credential helper %q is not available", name)
+	}
+	return helper
+}
+
+// SetPromptStreams routes credential prompts through the given streams instead
+// of the process's own stdin and stderr, for frontends which present prompts in
+// their own interface. The ASKPASS program is given in as its stdin, and both it
+// and 'git credential' write their stderr to out. Either may be nil to keep the
+// default.
+func (ctxt *CredentialHelperContext) SetPromptStreams(in io.Reader, out io.Writer) {
+	if ctxt.askpassCredHelper != nil {
+		ctxt.askpassCredHelper.PromptInput = in
+		ctxt.askpassCredHelper.PromptOutput = out
+	}
+	ctxt.commandCredHelper.PromptOutput = out
+}
+
+// SetInputAugmenter sets a function which is given a copy of the input of every
+// credential fill before any credential helper is consulted, and returns it
+// with any fields to add, such as a correlation ID. It may not remove or change
+// the "protocol" and "host" fields; if it does, its result is discarded. A nil
+// function disables augmentation.
+func (ctxt *CredentialHelperContext) SetInputAugmenter(f func(Creds) Creds) {
+	ctxt.augmentInput = f
+}
+
+// SetTransport sets a function which returns the http.RoundTripper for the
+// requests that credential helpers make of their own, such as to a Vault
+// server or an OAuth token endpoint, so that they use the same proxy,
+// certificate authorities and "http.<url>.sslVerify" as other requests. It is
+// called once for each HTTP client, and should return a new transport each
+// time. A nil function uses http.DefaultTransport.
+func (ctxt *CredentialHelperContext) SetTransport(f func(*url.URL) (http.RoundTripper, error)) {
+	ctxt.transport = f
+}
+
+// httpClient returns an HTTP client for the requests credential helpers make
+// to the given URL, with the transport set by SetTransport, if any.
+func (ctxt *CredentialHelperContext) httpClient(rawurl string) (*http.Client, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	if ctxt.transport == nil {
+		return client, nil
+	}
 
-	username, err := a.getValue(what, credValueTypeUsername, u)
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, errors.Wrapf(err, "creds: parsing %q", rawurl)
+	}
+	tr, err := ctxt.transport(u)
 	if err != nil {
 		return nil, err
 	}
-	creds["username"] = username
+	client.Transport = tr
+	return client, nil
+}
+
+// CredentialEvent describes the approval or rejection of credentials, for an
+// audit trail. It never includes the credentials' secrets.
+type CredentialEvent struct {
+	Protocol string
+	Host     string
+	Path     string
+	Username string
+	Time     time.Time
+
+	// Err is the error with which the credentials could not be approved
+	// or rejected, if any.
+	Err error
+
+	// Rollback is true for a rejection of credentials which were
+	// approved by one credential helper, but which another failed to
+	// approve. Err is the error with which it failed.
+	Rollback bool
+}
+
+func newCredentialEvent(creds Creds, err error) CredentialEvent {
+	return CredentialEvent{
+		Protocol: creds[CredsProtocol],
+		Host:     creds[CredsHost],
+		Path:     creds[CredsPath],
+		Username: creds[CredsUsername],
+		Time:     timeNow(),
+		Err:      err,
+	}
+}
+
+// OnApprove sets a function which is given a CredentialEvent each time
+// credentials are approved, after every credential helper has been asked to
+// approve them, such as to keep an audit log. A nil function disables the
+// callback.
+func (ctxt *CredentialHelperContext) OnApprove(f func(CredentialEvent)) {
+	ctxt.approveEvent = f
+}
+
+// OnReject sets a function which is given a CredentialEvent each time
+// credentials are rejected, including when they are rejected because a
+// credential helper failed to approve them. A nil function disables the
+// callback.
+func (ctxt *CredentialHelperContext) OnReject(f func(CredentialEvent)) {
+	ctxt.rejectEvent = f
+}
+
+// augmentedInput returns the given input as augmented by the function set with
+// SetInputAugmenter, if any.
+func (ctxt *CredentialHelperContext) augmentedInput(input Creds) Creds {
+	if ctxt.augmentInput == nil {
+		return input
+	}
+
+	augmented := ctxt.augmentInput(copyCreds(input))
+	for _, key := range []string{CredsProtocol, CredsHost} {
+		if v, ok := augmented[key]; !ok || v != input[key] {
+			tracerx.Printf("creds: ignoring augmented input for %s://%s, which changed %q",
+				input[CredsProtocol], input[CredsHost], key)
+			return input
+		}
+	}
+	return augmented
+}
+
+// Prefill fills credentials for each of the given URLs up front, so that any
+// prompting happens before a transfer begins, rather than part-way through it.
+// URLs which share a credential cache key are only filled once. The filled
+// credentials are cached in memory, and are approved or rejected as usual once
+// they are used. Prefill does nothing if caching is disabled.
+func (ctxt *CredentialHelperContext) Prefill(urls []*url.URL) error {
+	return ctxt.prefill(nil, urls)
+}
+
+func (ctxt *CredentialHelperContext) prefill(helper CredentialHelper, urls []*url.URL) error {
+	if ctxt.cachingCredHelper == nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var errs []string
+	for _, u := range urls {
+		credWrapper := ctxt.GetCredentialHelper(helper, u)
+		key := credCacheKey(credWrapper.Input)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if err := credWrapper.FillCreds(); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		ctxt.cachingCredHelper.promote(credWrapper.Input, credWrapper.Creds)
+	}
+
+	if len(errs) > 0 {
+		return errors.New("credential prefill errors:\n" + strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// FillOTP prompts for a one-time code for the given URL with the GIT_ASKPASS,
+// core.askpass, or SSH_ASKPASS program, for servers which require one in
+// addition to the password. It returns an error if there is no such program,
+// or "credential.interactive" is "never".
+func (ctxt *CredentialHelperContext) FillOTP(u *url.URL) (string, error) {
+	if ctxt.interactive == interactiveNever {
+		return "", errors.Errorf("a one-time code is required for %s, but credential.interactive is %q", SanitizeURL(u), "never")
+	}
+	if ctxt.askpassCredHelper == nil {
+		return "", errors.Errorf("a one-time code is required for %s, but there is no askpass program to prompt for it; set GIT_ASKPASS or core.askpass", SanitizeURL(u))
+	}
+	return ctxt.askpassCredHelper.FillOTP(u)
+}
+
+// CachedCreds returns a copy of the credentials cached in memory for the given
+// URL, if any, without consulting any credential helper, so that, for example,
+// an OAuth access token may be refreshed with their "oauth_refresh_token"
+// before it expires. It returns false if caching is disabled.
+func (ctxt *CredentialHelperContext) CachedCreds(u *url.URL) (Creds, bool) {
+	if ctxt.cachingCredHelper == nil {
+		return nil, false
+	}
+	credWrapper := ctxt.peekCredentialHelper(u)
+	if _, ok := credWrapper.CredentialHelper.(*refusedCredentialHelper); ok {
+		return nil, false
+	}
+	return ctxt.cachingCredHelper.lookup(credWrapper.Input)
+}
+
+// CredentialCacheSnapshot returns the keys of the credentials cached in memory,
+// which identify the hosts with cached credentials without revealing them. It
+// returns nil if caching is disabled.
+func (ctxt *CredentialHelperContext) CredentialCacheSnapshot() []CredKey {
+	if ctxt.cachingCredHelper == nil {
+		return nil
+	}
+	return ctxt.cachingCredHelper.Snapshot()
+}
+
+// ClearCache empties the in-memory credential cache, if caching is enabled.
+// Subsequent fills are satisfied by the remaining credential helpers.
+func (ctxt *CredentialHelperContext) ClearCache() {
+	if ctxt.cachingCredHelper != nil {
+		ctxt.cachingCredHelper.Flush()
+	}
+}
+
+// RejectHost rejects all credentials for the given protocol and host, as when
+// logging out of it. Credentials cached for any path, username or scope of the
+// host are removed from the cache, and every other credential helper which
+// would be consulted for the host is asked to reject its credentials, with
+// only the protocol and host given, so that 'git credential reject' erases
+// those stored for any path or username.
+func (ctxt *CredentialHelperContext) RejectHost(protocol, host string) error {
+	u := &url.URL{Scheme: protocol, Host: normalizeHost(host)}
+	wrapper := ctxt.peekCredentialHelper(u)
+	what := Creds{
+		CredsProtocol: wrapper.Input[CredsProtocol],
+		CredsHost:     wrapper.Input[CredsHost],
+	}
+
+	tracerx.Printf("creds: rejecting all credentials for (%q, %q)", what[CredsProtocol], what[CredsHost])
+	if ctxt.cachingCredHelper != nil {
+		ctxt.cachingCredHelper.rejectHost(what[CredsProtocol], what[CredsHost])
+	}
+
+	if chain, ok := wrapper.CredentialHelper.(*CredentialHelpers); ok {
+		return chain.rejectAll(what)
+	}
+	return wrapper.CredentialHelper.Reject(what)
+}
+
+// getCredentialHelper parses a 'credsConfig' from the git and OS environments,
+// returning the appropriate CredentialHelper to authenticate requests with.
+//
+// It returns an error if any configuration was invalid, or otherwise
+// un-useable.
+//
+// The username is taken from the URL if it has one, and otherwise from
+// "credential.<url>.username". Either takes precedence over a username
+// returned by a credential helper in the returned chain.
+func (ctxt *CredentialHelperContext) GetCredentialHelper(helper CredentialHelper, u *url.URL) CredentialHelperWrapper {
+	return ctxt.GetCredentialHelperWithHints(helper, u, CredentialHints{})
+}
+
+// GetProxyCredentialHelper works like GetCredentialHelper, but for the given
+// HTTP proxy URL. The credentials are filled through the same chain of
+// credential helpers, with the proxy's protocol and host, and a "scope" of
+// "proxy", so that they are kept apart from credentials for the origin.
+func (ctxt *CredentialHelperContext) GetProxyCredentialHelper(helper CredentialHelper, proxy *url.URL) CredentialHelperWrapper {
+	u := &url.URL{Scheme: proxy.Scheme, Host: proxy.Host, User: proxy.User}
+	wrapper := ctxt.GetCredentialHelperWithHints(helper, u, CredentialHints{})
+	wrapper.Input[CredsScope] = CredsScopeProxy
+	return wrapper
+}
+
+// PendingApprovals holds the credentials whose approval was deferred by one
+// transfer, such as a transfer queue, so that they are approved once it has
+// completed successfully, or never if it failed, regardless of other transfers
+// using the same CredentialHelperContext. Of the credentials deferred for the
+// same host, only the last is approved, so that a transfer making many
+// requests to a host runs 'git credential approve' once for it.
+type PendingApprovals struct {
+	ctxt *CredentialHelperContext
+
+	// wrappers maps the key of each host, as given by pendingApprovalKey,
+	// to the credentials most recently deferred for it. It is guarded by
+	// ctxt.pendingMu.
+	wrappers map[string]CredentialHelperWrapper
+}
+
+// NewPendingApprovals returns an empty set of deferred approvals, for one
+// transfer to defer the approval of the credentials it uses into.
+func (ctxt *CredentialHelperContext) NewPendingApprovals() *PendingApprovals {
+	return &PendingApprovals{
+		ctxt:     ctxt,
+		wrappers: make(map[string]CredentialHelperWrapper),
+	}
+}
+
+// Defer records that the credentials filled in the given wrapper were
+// accepted, so that they are approved by Commit rather than at once.
+func (p *PendingApprovals) Defer(credWrapper CredentialHelperWrapper) {
+	if credWrapper.Creds == nil {
+		return
+	}
+
+	p.ctxt.pendingMu.Lock()
+	p.wrappers[pendingApprovalKey(credWrapper.Creds)] = credWrapper
+	p.ctxt.pendingSets[p] = true
+	p.ctxt.pendingMu.Unlock()
+}
+
+// Commit approves the credentials deferred with Defer, once per host, in one
+// pass. It should be called once the transfer has completed successfully.
+func (p *PendingApprovals) Commit() {
+	approvePending(p.take())
+}
+
+// Discard forgets the credentials deferred with Defer, without approving
+// them. It should be called if the transfer failed.
+func (p *PendingApprovals) Discard() {
+	p.take()
+}
+
+// take empties the set, returning the credentials deferred into it.
+func (p *PendingApprovals) take() map[string]CredentialHelperWrapper {
+	p.ctxt.pendingMu.Lock()
+	defer p.ctxt.pendingMu.Unlock()
+
+	pending := p.wrappers
+	p.wrappers = make(map[string]CredentialHelperWrapper)
+	delete(p.ctxt.pendingSets, p)
+	return pending
+}
+
+// CommitApprovals approves the credentials deferred by every transfer whose
+// approvals have been neither committed nor discarded, once per host across
+// all of them, in one pass. Of the credentials deferred for the same host by
+// different transfers, those of one of them are approved. It is for callers
+// which run many transfers and know that all of them have succeeded.
+func (ctxt *CredentialHelperContext) CommitApprovals() {
+	ctxt.pendingMu.Lock()
+	pending := make(map[string]CredentialHelperWrapper)
+	for p := range ctxt.pendingSets {
+		for key, credWrapper := range p.wrappers {
+			pending[key] = credWrapper
+		}
+		p.wrappers = make(map[string]CredentialHelperWrapper)
+		delete(ctxt.pendingSets, p)
+	}
+	ctxt.pendingMu.Unlock()
+
+	approvePending(pending)
+}
+
+// approvePending approves the given deferred credentials, ordered by the key
+// of their host.
+func approvePending(pending map[string]CredentialHelperWrapper) {
+	keys := make([]string, 0, len(pending))
+	for key := range pending {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		credWrapper := pending[key]
+		tracerx.Printf("creds: approving deferred credentials for %s", SanitizeURL(credWrapper.Url))
+		credWrapper.CredentialHelper.Approve(credWrapper.Creds)
+	}
+}
+
+// DiscardApproval forgets any deferred approval of credentials for the host of
+// the given Creds, by any transfer, since they have since been rejected.
+func (ctxt *CredentialHelperContext) DiscardApproval(creds Creds) {
+	key := pendingApprovalKey(creds)
+
+	ctxt.pendingMu.Lock()
+	for p := range ctxt.pendingSets {
+		delete(p.wrappers, key)
+	}
+	ctxt.pendingMu.Unlock()
+}
+
+// pendingApprovalKey returns the key under which the approval of the given
+// Creds is deferred: their protocol, host, path, if "credential.useHttpPath"
+// is enabled, and scope. Hosts differing only in case, or in the form of an
+// IPv6 literal address, have the same key.
+func pendingApprovalKey(creds Creds) string {
+	return CredKey{
+		Protocol: creds[CredsProtocol],
+		Host:     strings.ToLower(hostKey(creds[CredsHost])),
+		Path:     creds[CredsPath],
+		Scope:    creds[CredsScope],
+	}.String()
+}
+
+// HasNegotiateCredentialHelper returns whether credentials for SPNEGO
+// ("Negotiate") authentication are filled when the server challenges with
+// "Negotiate", as "lfs.negotiatecredentialhelper" is enabled.
+func (ctxt *CredentialHelperContext) HasNegotiateCredentialHelper() bool {
+	return ctxt.negotiateCredHelper != nil
+}
+
+// GetNegotiateCredentialHelper returns a CredentialHelperWrapper which fills
+// credentials for the given URL from the negotiate credential helper alone,
+// so that no other credential helper is run and the user is never prompted,
+// and whether that helper is enabled.
+func (ctxt *CredentialHelperContext) GetNegotiateCredentialHelper(u *url.URL, hints CredentialHints) (CredentialHelperWrapper, bool) {
+	if ctxt.negotiateCredHelper == nil {
+		return CredentialHelperWrapper{}, false
+	}
+
+	hints.Challenge = "Negotiate"
+	wrapper, _ := ctxt.selectCredentialHelper(ctxt.negotiateCredHelper, u, hints)
+	return wrapper, true
+}
+
+// CredentialHints describe what credentials are needed for, so that credential
+// helpers which understand them can issue credentials with the least
+// privileges required.
+type CredentialHints struct {
+	// Operation is either "upload" or "download".
+	Operation string
+	// Ref is the fully qualified name of the ref being operated on.
+	Ref string
+	// Challenge is the authentication scheme the server challenged with,
+	// such as "Negotiate", if known. It is not sent to credential helpers.
+	Challenge string
+	// Remote is the name of the Git remote being operated on, if known.
+	// It is not sent to credential helpers, but if the remote has any
+	// "remote.<name>.credentialHelper" entries, they are used in place
+	// of the "credential.helper" entries for the URL.
+	Remote string
+}
+
+// GetCredentialHelperWithHints works like GetCredentialHelper, but also passes
+// the given hints to credential helpers as the non-standard "operation" and
+// "ref" keys, if "credential.<url>.sendHints" is enabled. Helpers which do not
+// understand these keys ignore them. If the hints name a "Negotiate" challenge,
+// the negotiate credential helper, if enabled, is consulted first.
+func (ctxt *CredentialHelperContext) GetCredentialHelperWithHints(helper CredentialHelper, u *url.URL, hints CredentialHints) CredentialHelperWrapper {
+	wrapper, apply := ctxt.selectCredentialHelper(helper, u, hints)
+	apply()
+	return wrapper
+}
+
+// peekCredentialHelper returns the CredentialHelperWrapper which
+// GetCredentialHelper would return for the given URL, without consuming the
+// username of rejected credentials or caching configured ones, for callers
+// which only inspect it or reject credentials.
+func (ctxt *CredentialHelperContext) peekCredentialHelper(u *url.URL) CredentialHelperWrapper {
+	wrapper, _ := ctxt.selectCredentialHelper(nil, u, CredentialHints{})
+	return wrapper
+}
+
+// selectCredentialHelper chooses the CredentialHelperWrapper for the given URL
+// as GetCredentialHelperWithHints does, without changing any state. It also
+// returns a function which applies the changes that using the wrapper implies:
+// forgetting the username of the rejected credentials given in its input, and
+// caching any credentials configured for the URL.
+func (ctxt *CredentialHelperContext) selectCredentialHelper(helper CredentialHelper, u *url.URL, hints CredentialHints) (CredentialHelperWrapper, func()) {
+	credsURL := ctxt.groupURL(ctxt.unaliasURL(u))
+	rawurl := fmt.Sprintf("%s://%s%s", credsURL.Scheme, credsURL.Host, credsURL.Path)
+	input := CredsFromURL(credsURL, credsURL.Scheme == "cert" || ctxt.urlConfig.Bool("credential", rawurl, "usehttppath", false))
+	if sameAs, ok := ctxt.urlConfig.Get("credential", rawurl, "sameas"); ok && len(sameAs) > 0 {
+		input[CredsHost] = sameAsHost(sameAs)
+	}
+	input[CredsHost] = normalizeHost(input[CredsHost])
+
+	// Any username already known is always given to the credential
+	// helpers, so that 'git credential' prompts only for the password. It
+	// is taken from the URL, then from "credential.<url>.username", then
+	// from the credentials last rejected for the host. One from the URL or
+	// configuration takes precedence over any other filled by a credential
+	// helper, while one from rejected credentials is only a hint.
+	if _, ok := input[CredsUsername]; !ok {
+		if username, ok := ctxt.urlConfig.Get("credential", rawurl, "username"); ok && len(username) > 0 {
+			input[CredsUsername] = username
+		}
+	}
+	requestedUsername := input[CredsUsername]
+	var rejected Creds
+	if _, ok := input[CredsUsername]; !ok {
+		if username, ok := ctxt.rejectedUsername(input); ok {
+			rejected = copyCreds(input)
+			input[CredsUsername] = username
+		}
+	}
+	forgetRejected := func() {
+		if rejected != nil {
+			ctxt.forgetRejectedUsername(rejected)
+		}
+	}
+	cacheUsernameOnly := ctxt.cachingCredHelper != nil && ctxt.urlConfig.Bool("credential", rawurl, "cacheusernameonly", false)
+	if _, ok := input[CredsUsername]; !ok && cacheUsernameOnly {
+		if username, ok := ctxt.cachingCredHelper.username(input); ok {
+			input[CredsUsername] = username
+		}
+	}
+	if authtype, ok := ctxt.urlConfig.Get("credential", rawurl, "authtype"); ok && len(authtype) > 0 {
+		input[CredsAuthtype] = authtype
+	}
+	if ctxt.urlConfig.Bool("credential", rawurl, "sendhints", false) {
+		if len(hints.Operation) > 0 {
+			input[CredsOperation] = hints.Operation
+		}
+		if len(hints.Ref) > 0 {
+			input[CredsRef] = hints.Ref
+		}
+	}
+	for _, extra := range ctxt.urlConfig.GetAll("credential", rawurl, "extra") {
+		pieces := strings.SplitN(extra, "=", 2)
+		if len(pieces) < 2 || len(pieces[0]) == 0 {
+			tracerx.Printf("creds: ignoring invalid credential.extra %q", extra)
+			continue
+		}
+		if _, ok := input[pieces[0]]; !ok {
+			input[pieces[0]] = pieces[1]
+		}
+	}
+	input = ctxt.augmentedInput(input)
+
+	if ctxt.recursionErr != nil {
+		return CredentialHelperWrapper{CredentialHelper: &refusedCredentialHelper{err: ctxt.recursionErr}, Input: input, Url: u}, forgetRejected
+	}
+
+	if err := ctxt.attemptsExceeded(input); err != nil {
+		return CredentialHelperWrapper{CredentialHelper: &refusedCredentialHelper{err: err}, Input: input, Url: u}, forgetRejected
+	}
+
+	transform := valueTransform(ctxt.urlConfig.GetAll("credential", rawurl, "valuetransform"))
+
+	if !ctxt.allowInsecure && !secureCredentialProtocols[credsURL.Scheme] {
+		err := errors.Errorf("refusing to send credentials to %s over insecure protocol %q; set lfs.credentialAllowInsecure to allow this",
+			credsURL.Host, credsURL.Scheme)
+		return CredentialHelperWrapper{CredentialHelper: &refusedCredentialHelper{err: err}, Input: input, Url: u}, forgetRejected
+	}
+
+	if helper != nil {
+		return CredentialHelperWrapper{CredentialHelper: helper, Input: input, Url: u, Transform: transform}, forgetRejected
+	}
+
+	if ctxt.stdinCredHelper != nil {
+		return CredentialHelperWrapper{CredentialHelper: ctxt.stdinCredHelper, Input: input, Url: u, Transform: transform}, forgetRejected
+	}
+
+	helpers := make([]CredentialHelper, 0, 8)
+	if ctxt.negotiateCredHelper != nil && strings.EqualFold(hints.Challenge, "Negotiate") {
+		helpers = append(helpers, ctxt.negotiateCredHelper)
+	}
+	if ctxt.netrcCredHelper != nil {
+		helpers = append(helpers, ctxt.netrcCredHelper)
+	}
+	if ctxt.refreshCredHelper != nil {
+		if _, ok := ctxt.refreshCredHelper.tokenEndpoint(configURL(input)); ok {
+			helpers = append(helpers, ctxt.refreshCredHelper)
+		}
+	}
+	// With "credential.<url>.cacheUsernameOnly", only the username is
+	// cached, and given in the input above, so the password is filled
+	// again each time.
+	promptOnce := ctxt.promptOnce
+	if cacheUsernameOnly {
+		promptOnce = func(h CredentialHelper) CredentialHelper { return h }
+	} else if ctxt.cachingCredHelper != nil {
+		helpers = append(helpers, ctxt.cachingCredHelper)
+	}
+	if ctxt.vaultCredHelper != nil {
+		helpers = append(helpers, ctxt.external(ctxt.vaultCredHelper, rawurl))
+	}
+	if ctxt.brokerCredHelper != nil {
+		if _, ok := ctxt.brokerCredHelper.endpoint(input); ok {
+			helpers = append(helpers, ctxt.external(ctxt.brokerCredHelper, rawurl))
+		}
+	}
+	if ctxt.processCredHelper != nil {
+		helpers = append(helpers, ctxt.external(ctxt.processCredHelper, rawurl))
+	}
+	helperEntries := ctxt.credentialHelpers(rawurl)
+	var command CredentialHelper = ctxt.commandCredHelper
+	if len(ctxt.envHelper) > 0 {
+		tracerx.Printf("creds: using credential helper %q from GIT_LFS_CREDENTIAL_HELPER", ctxt.envHelper)
+		helperEntries = []string{ctxt.envHelper}
+		command = &remoteCommandCredentialHelper{
+			commandCredentialHelper: ctxt.commandCredHelper,
+			helpers:                 helperEntries,
+			source:                  "GIT_LFS_CREDENTIAL_HELPER",
+		}
+	} else if remoteHelpers, ok := ctxt.remoteCredentialHelpers(hints.Remote); ok {
+		tracerx.Printf("creds: using credential helpers configured for remote %q", hints.Remote)
+		helperEntries = remoteHelpers
+		command = &remoteCommandCredentialHelper{
+			commandCredentialHelper: ctxt.commandCredHelper,
+			helpers:                 remoteHelpers,
+			source:                  fmt.Sprintf("remote.%s.credentialhelper", hints.Remote),
+		}
+	}
+	hasHelper := len(helperEntries) > 0
+	if command == CredentialHelper(ctxt.commandCredHelper) && ctxt.credentialHelpersReset(rawurl) {
+		// With no credential helper, approving and rejecting
+		// credentials with 'git credential' does nothing, so is not
+		// done, and filling them only prompts.
+		tracerx.Printf("creds: %q is reset for %s, so 'git credential' only prompts", "credential.helper", rawurl)
+		command = &readOnlyCredentialHelper{command}
+	}
+	if ctxt.builtinCredHelper != nil {
+		if !ctxt.builtinCredHelperAuto || !hasHelper {
+			helpers = append(helpers, ctxt.external(ctxt.builtinCredHelper, rawurl))
+		}
+	}
+	if ctxt.askpassCredHelper != nil && !hasHelper && ctxt.interactive != interactiveNever {
+		helpers = append(helpers, promptOnce(ctxt.askpassCredHelper))
+	}
+	helpers = append(helpers, promptOnce(ctxt.external(command, rawurl)))
+	if ctxt.terminalCredHelper != nil && ctxt.interactive != interactiveNever {
+		helpers = append(helpers, promptOnce(ctxt.terminalCredHelper))
+	}
+	chain := newCredentialHelpers(helpers)
+	chain.requestedUsername = requestedUsername
+	chain.strictMatch = ctxt.strictMatch
+	chain.mergePartial = ctxt.mergePartial
+	chain.onReject = func(rejected Creds) { ctxt.rejected(input, rejected) }
+	chain.onApprove = func(_ Creds) { ctxt.approved(input) }
+	if cacheUsernameOnly {
+		chain.onReject = func(rejected Creds) {
+			ctxt.rejected(input, rejected)
+			ctxt.cachingCredHelper.rejectUsername(input, rejected[CredsUsername])
+		}
+		chain.onApprove = func(approved Creds) {
+			ctxt.approved(input)
+			ctxt.cachingCredHelper.approveUsername(input, approved[CredsUsername])
+		}
+	}
+	chain.approveEvent = ctxt.approveEvent
+	chain.rejectEvent = ctxt.rejectEvent
+	apply := func() {
+		forgetRejected()
+		ctxt.seedConfiguredCreds(input, rawurl)
+	}
+	return CredentialHelperWrapper{CredentialHelper: chain, Input: input, Url: u, Transform: transform}, apply
+}
+
+// seedConfiguredCreds caches the credentials given for the URL by both
+// "credential.<url>.username" and "credential.<url>.password", if any, as if
+// they had been filled and approved, so that no other credential helper is
+// consulted for them. Nothing is cached if caching is disabled, or if the
+// username requested, such as one given in the URL, is a different one.
+func (ctxt *CredentialHelperContext) seedConfiguredCreds(input Creds, rawurl string) {
+	if ctxt.cachingCredHelper == nil {
+		return
+	}
+
+	password, ok := ctxt.urlConfig.Get("credential", rawurl, "password")
+	if !ok || len(password) == 0 {
+		return
+	}
+	username, _ := ctxt.urlConfig.Get("credential", rawurl, "username")
+	if len(username) == 0 || input[CredsUsername] != username {
+		return
+	}
+
+	creds := copyCreds(input)
+	creds[CredsPassword] = password
+	if ctxt.cachingCredHelper.Approve(creds) == credHelperNoOp {
+		tracerx.Printf("creds: cached credentials configured for %s", rawurl)
+	}
+}
+
+// credentialHelpers returns the "credential.helper" entries Git would use for
+// the given URL: those without a URL, followed by those for the URL which
+// matches it best, if any. As with Git, an empty entry clears the entries
+// before it.
+func (ctxt *CredentialHelperContext) credentialHelpers(rawurl string) []string {
+	var helpers []string
+	for _, entry := range ctxt.credentialHelperEntries(rawurl) {
+		if len(entry) == 0 {
+			helpers = nil
+			continue
+		}
+		helpers = append(helpers, entry)
+	}
+	return helpers
+}
+
+// credentialHelpersReset returns whether "credential.helper" entries apply to
+// the given URL, but the last is empty, so that Git uses no credential helper
+// for it, and 'git credential' can only prompt.
+func (ctxt *CredentialHelperContext) credentialHelpersReset(rawurl string) bool {
+	entries := ctxt.credentialHelperEntries(rawurl)
+	return len(entries) > 0 && len(ctxt.credentialHelpers(rawurl)) == 0
+}
+
+// credentialHelperEntries returns the "credential.helper" entries which apply
+// to the given URL, including empty ones, in the order Git reads them.
+func (ctxt *CredentialHelperContext) credentialHelperEntries(rawurl string) []string {
+	entries := append([]string{}, ctxt.genericHelpers...)
+	if urlEntries, ok := ctxt.urlConfig.GetAllForURL("credential", rawurl, "helper"); ok {
+		entries = append(entries, urlEntries...)
+	}
+	return entries
+}
+
+// valueTransform returns a function applying the given
+// "credential.<url>.valueTransform" steps, in order, to the credential value of
+// filled Creds: its "credential" if it has one, and otherwise its password. Each step is one of:
+//
+//   - "base64", which base64-encodes the value,
+//   - "prefix:<text>", which prepends <text> to the value, or
+//   - "authtype:<scheme>", which sends the value with the given "authtype",
+//     such as "Bearer", rather than as a password.
+//
+// It returns nil if there are no steps.
+func valueTransform(steps []string) func(Creds) (Creds, error) {
+	if len(steps) == 0 {
+		return nil
+	}
+
+	return func(creds Creds) (Creds, error) {
+		transformed := make(Creds, len(creds))
+		for k, v := range creds {
+			transformed[k] = v
+		}
+
+		key := CredsPassword
+		if len(transformed[CredsCredential]) > 0 {
+			key = CredsCredential
+		}
+
+		for _, step := range steps {
+			pieces := strings.SplitN(step, ":", 2)
+			switch {
+			case step == "base64":
+				transformed[key] = base64.StdEncoding.EncodeToString([]byte(transformed[key]))
+			case len(pieces) == 2 && pieces[0] == "prefix":
+				transformed[key] = pieces[1] + transformed[key]
+			case len(pieces) == 2 && pieces[0] == "authtype" && len(pieces[1]) > 0:
+				if key == CredsPassword {
+					transformed[CredsCredential] = transformed[CredsPassword]
+					key = CredsCredential
+				}
+				transformed[CredsAuthtype] = pieces[1]
+			default:
+				return nil, errors.Errorf("invalid credential.valueTransform %q", step)
+			}
+		}
+
+		return transformed, nil
+	}
+}
+
+// remoteCredentialHelpers returns the "remote.<name>.credentialHelper" entries
+// for the given remote, and whether there were any, in which case they replace
+// the "credential.helper" entries Git would otherwise use. As with those, an
+// empty entry clears the entries before it, so a remote may be configured to
+// use no credential helper at all.
+func (ctxt *CredentialHelperContext) remoteCredentialHelpers(remote string) ([]string, bool) {
+	if len(remote) == 0 || ctxt.gitEnv == nil {
+		return nil, false
+	}
+
+	entries := ctxt.gitEnv.GetAll(fmt.Sprintf("remote.%s.credentialhelper", remote))
+	if len(entries) == 0 {
+		return nil, false
+	}
+
+	helpers := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if len(entry) == 0 {
+			helpers = helpers[:0]
+			continue
+		}
+		helpers = append(helpers, entry)
+	}
+	return helpers, true
+}
+
+// rejected records the given rejected Creds, filled for the Creds "what",
+// remembering their username and counting the rejection against the
+// "lfs.maxCredentialAttempts" for the requested host, since credential helpers
+// such as askpass return no host.
+func (ctxt *CredentialHelperContext) rejected(what, rejected Creds) {
+	ctxt.rememberRejectedUsername(rejected)
+
+	ctxt.rejectedMu.Lock()
+	ctxt.rejections[attemptKey(what)]++
+	ctxt.rejectedMu.Unlock()
+}
+
+// approved resets the count of rejections for the host of the given requested
+// Creds, whose filled credentials were approved.
+func (ctxt *CredentialHelperContext) approved(approved Creds) {
+	ctxt.rejectedMu.Lock()
+	delete(ctxt.rejections, attemptKey(approved))
+	ctxt.rejectedMu.Unlock()
+}
+
+// attemptsExceeded returns an error if credentials for the host of the given
+// Creds have been rejected "lfs.maxCredentialAttempts" times since they were
+// last approved, so that a server which rejects every credential does not
+// cause the user to be prompted endlessly.
+func (ctxt *CredentialHelperContext) attemptsExceeded(what Creds) error {
+	if ctxt.maxAttempts <= 0 {
+		return nil
+	}
+
+	ctxt.rejectedMu.Lock()
+	rejections := ctxt.rejections[attemptKey(what)]
+	ctxt.rejectedMu.Unlock()
+
+	if rejections < ctxt.maxAttempts {
+		return nil
+	}
+	return errors.Errorf("credentials for %s://%s were rejected %d times; giving up (see lfs.maxCredentialAttempts)",
+		what[CredsProtocol], what[CredsHost], rejections)
+}
+
+// attemptKey returns the key under which rejections of the given Creds are
+// counted: their protocol, host and scope.
+func attemptKey(creds Creds) string {
+	return CredKey{
+		Protocol: creds[CredsProtocol],
+		Host:     strings.ToLower(hostKey(creds[CredsHost])),
+		Scope:    creds[CredsScope],
+	}.String()
+}
+
+// rememberRejectedUsername records the username of the given rejected Creds, so
+// that it is used the next time credentials with the same key are filled.
+func (ctxt *CredentialHelperContext) rememberRejectedUsername(rejected Creds) {
+	username, ok := rejected[CredsUsername]
+	if !ok || len(username) == 0 {
+		return
+	}
+
+	ctxt.rejectedMu.Lock()
+	ctxt.rejectedUsernames[credLookupKey(rejected)] = username
+	ctxt.rejectedMu.Unlock()
+}
+
+// rejectedUsername returns the username of the last rejected credentials with
+// the same key as the given Creds, if any.
+func (ctxt *CredentialHelperContext) rejectedUsername(what Creds) (string, bool) {
+	ctxt.rejectedMu.Lock()
+	defer ctxt.rejectedMu.Unlock()
+
+	username, ok := ctxt.rejectedUsernames[credLookupKey(what)]
+	return username, ok
+}
+
+// forgetRejectedUsername forgets the username of the last rejected credentials
+// with the same key as the given Creds, once it has been given to the
+// credential helpers, so that a wrong username is not kept indefinitely.
+func (ctxt *CredentialHelperContext) forgetRejectedUsername(what Creds) {
+	ctxt.rejectedMu.Lock()
+	delete(ctxt.rejectedUsernames, credLookupKey(what))
+	ctxt.rejectedMu.Unlock()
+}
+
+// secureCredentialProtocols is the set of protocols over which credentials
+// may be sent if "lfs.credentialAllowInsecure" is disabled.
+var secureCredentialProtocols = map[string]bool{
+	"https": true,
+	"ssh":   true,
+	"cert":  true,
+}
+
+// credentialRecursionEnv is set in the environment of 'git credential' when Git
+// LFS runs it. If a Git LFS process finds it set, a misconfigured credential
+// helper has run Git LFS again, and filling credentials would recurse.
+const credentialRecursionEnv = "GIT_LFS_IN_CREDENTIAL_HELPER"
+
+// refusedCredentialHelper is a CredentialHelper which refuses to fill any
+// credentials, returning an error explaining why.
+type refusedCredentialHelper struct {
+	err error
+}
+
+func (h *refusedCredentialHelper) Name() string                { return "refused" }
+func (h *refusedCredentialHelper) Fill(_ Creds) (Creds, error) { return nil, h.err }
+func (h *refusedCredentialHelper) Approve(_ Creds) error       { return nil }
+func (h *refusedCredentialHelper) Reject(_ Creds) error        { return nil }
+
+// promptOnce returns the given CredentialHelper, which may prompt the user,
+// wrapped so that the credentials it fills are cached in memory, if caching is
+// enabled.
+func (ctxt *CredentialHelperContext) promptOnce(h CredentialHelper) CredentialHelper {
+	if ctxt.cachingCredHelper != nil {
+		return &promptOnceCredentialHelper{CredentialHelper: h, cache: ctxt.cachingCredHelper}
+	}
+	return h
+}
+
+// SanitizeURL returns the given URL as a string suitable for logging, with any
+// password in its userinfo redacted. The username is kept.
+func SanitizeURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	if _, ok := u.User.Password(); !ok {
+		return u.String()
+	}
+
+	sanitized := *u
+	sanitized.User = url.UserPassword(u.User.Username(), "xxxxx")
+	return sanitized.String()
+}
+
+// configURL returns the URL of the given Creds, by which the
+// "credential.<url>.*" configuration for them is looked up.
+func configURL(creds Creds) string {
+	rawurl := fmt.Sprintf("%s://%s", creds[CredsProtocol], creds[CredsHost])
+	if path := creds[CredsPath]; len(path) > 0 {
+		rawurl += "/" + strings.TrimPrefix(path, "/")
+	}
+	return rawurl
+}
+
+// sameAsHost returns the host named by a "credential.<url>.sameAs" value, which
+// may be either a bare host or a URL.
+func sameAsHost(sameAs string) string {
+	if strings.Contains(sameAs, "://") {
+		if u, err := url.Parse(sameAs); err == nil && len(u.Host) > 0 {
+			return u.Host
+		}
+	}
+	return sameAs
+}
+
+// external returns the given CredentialHelper, which is backed by a credential
+// store outside of this process, wrapped so that it ignores approvals and
+// rejections if "lfs.credentialsreadonly" is enabled, or only approvals if
+// "credential.<url>.approve" is disabled for the given URL.
+func (ctxt *CredentialHelperContext) external(h CredentialHelper, rawurl string) CredentialHelper {
+	if ctxt.readOnly {
+		return &readOnlyCredentialHelper{h}
+	}
+	if !ctxt.urlConfig.Bool("credential", rawurl, "approve", true) {
+		return &noApproveCredentialHelper{h}
+	}
+	return h
+}
+
+// readOnlyCredentialHelper wraps a CredentialHelper, passing calls to Fill
+// through to it, but never approving or rejecting credentials.
+type readOnlyCredentialHelper struct {
+	CredentialHelper
+}
+
+// Name implements NamedCredentialHelper.Name, returning the name of the
+// wrapped CredentialHelper.
+func (h *readOnlyCredentialHelper) Name() string {
+	return credentialHelperName(h.CredentialHelper)
+}
+
+// Approve implements CredentialHelper.Approve, and returns nil without
+// approving the given Creds.
+func (h *readOnlyCredentialHelper) Approve(_ Creds) error { return nil }
+
+// Reject implements CredentialHelper.Reject, and returns nil without
+// rejecting the given Creds.
+func (h *readOnlyCredentialHelper) Reject(_ Creds) error { return nil }
+
+// noApproveCredentialHelper wraps a CredentialHelper, passing calls to Fill and
+// Reject through to it, but never approving credentials, so that a credential
+// store which is managed elsewhere is not written to.
+type noApproveCredentialHelper struct {
+	CredentialHelper
+}
+
+// Name implements NamedCredentialHelper.Name, returning the name of the
+// wrapped CredentialHelper.
+func (h *noApproveCredentialHelper) Name() string {
+	return credentialHelperName(h.CredentialHelper)
+}
+
+// Approve implements CredentialHelper.Approve, and returns nil without
+// approving the given Creds.
+func (h *noApproveCredentialHelper) Approve(_ Creds) error { return nil }
+
+// AskPassCredentialHelper implements the CredentialHelper type for GIT_ASKPASS
+// and 'core.askpass' configuration values.
+type AskPassCredentialHelper struct {
+	// Program is the executable program's absolute or relative name.
+	Program string
+
+	// PromptInput, if non-nil, is given to the program as its stdin.
+	PromptInput io.Reader
+	// PromptOutput, if non-nil, receives a copy of the program's stderr.
+	PromptOutput io.Writer
+
+	// Timeout, if positive, is how long the program may run before it is
+	// killed and a timeout error returned.
+	Timeout time.Duration
+
+	// PromptTemplate, if non-empty, is the prompt given to the program, in
+	// which "%s" is replaced by the URL, "%t" by the value asked for,
+	// "Username" or "Password", and "%%" by "%". If empty, the prompt is
+	// as Git's, such as: Password for "https://example.com".
+	PromptTemplate string
+
+	// PasswordOnly is true if the program is only asked for the password,
+	// and the username is taken from the URL or configuration, as given
+	// in the Creds to fill, rather than prompted for. If there is none,
+	// the filled Creds have no username, and may be completed by the next
+	// credential helper if "lfs.credentialmergepartial" is enabled.
+	PasswordOnly bool
+
+	// allowedEnv, if non-nil, are the only environment variables, besides
+	// PATH, with which the program is run, as given by
+	// "lfs.credentialhelperenv".
+	allowedEnv []string
+}
+
+type credValueType int
+
+const (
+	credValueTypeUnknown credValueType = iota
+	credValueTypeUsername
+	credValueTypePassword
+	credValueTypeOTP
+)
+
+// FillOTP runs the ASKPASS program to prompt for a one-time code for the given
+// URL, as required by servers with two-factor authentication in addition to
+// the password, and returns it.
+func (a *AskPassCredentialHelper) FillOTP(u *url.URL) (string, error) {
+	return a.getFromProgram(credValueTypeOTP, u)
+}
+
+// Fill implements fill by running the ASKPASS program and returning its output
+// as a password encoded in the Creds type given the key "password".
+//
+// It accepts the password as coming from the program's stdout, as when invoked
+// with the given arguments (see (*AskPassCredentialHelper).args() below)./
+//
+// If there was an error running the command, it is returned instead of a set of
+// filled credentials.
+//
+// The ASKPASS program is only queried if a credential was not already
+// provided, i.e. through the git URL
+func (a *AskPassCredentialHelper) Fill(what Creds) (Creds, error) {
+	u := &url.URL{
+		Scheme: what[CredsProtocol],
+		Host:   what[CredsHost],
+		Path:   what[CredsPath],
+	}
+
+	creds := make(Creds)
+
+	if a.PasswordOnly && len(what[CredsUsername]) == 0 {
+		tracerx.Printf("creds: askpass only asks for the password, and no username is configured for %s", SanitizeURL(u))
+	} else {
+		username, err := a.getValue(what, credValueTypeUsername, u)
+		if err != nil {
+			return nil, err
+		}
+		creds[CredsUsername] = username
+	}
+
+	if username := creds[CredsUsername]; len(username) > 0 {
+		// If a non-empty username was given, add it to the URL via func
+		// 'net/url.User()'.
+		u.User = url.User(creds[CredsUsername])
+	}
+
+	password, err := a.getValue(what, credValueTypePassword, u)
+	if err != nil {
+		return nil, err
+	}
+	creds[CredsPassword] = password
+
+	return creds, nil
+}
+
+func (a *AskPassCredentialHelper) getValue(what Creds, valueType credValueType, u *url.URL) (string, error) {
+	var valueString string
+
+	switch valueType {
+	case credValueTypeUsername:
+		valueString = CredsUsername
+	case credValueTypePassword:
+		valueString = CredsPassword
+	default:
+		return "", errors.Errorf("Invalid Credential type queried from AskPass")
+	}
+
+	// Return the existing credential if it was already provided, otherwise
+	// query AskPass for it
+	if given, ok := what[valueString]; ok {
+		return given, nil
+	}
+	return a.getFromProgram(valueType, u)
+}
+
+func (a *AskPassCredentialHelper) getFromProgram(valueType credValueType, u *url.URL) (string, error) {
+	var (
+		value bytes.Buffer
+		err   bytes.Buffer
+
+		valueString string
+	)
+
+	switch valueType {
+	case credValueTypeUsername:
+		valueString = "Username"
+	case credValueTypePassword:
+		valueString = "Password"
+	case credValueTypeOTP:
+		valueString = "One-time code"
+	default:
+		return "", errors.Errorf("Invalid Credential type queried from AskPass")
+	}
+
+	// 'cmd' will run the GIT_ASKPASS (or core.askpass) command prompting
+	// for the desired valueType (`Username` or `Password`)
+	ctx := context.Background()
+	if a.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, a.Program, a.args(a.prompt(valueString, u))...)
+	cmd.Env = credentialHelperEnv(a.allowedEnv)
+	cmd.Stdin = a.PromptInput
+	cmd.Stderr = &err
+	if a.PromptOutput != nil {
+		cmd.Stderr = io.MultiWriter(&err, a.PromptOutput)
+	}
+	cmd.Stdout = &value
+
+	tracerx.Printf("creds: filling with GIT_ASKPASS: %s", strings.Join(cmd.Args, " "))
+	started := time.Now()
+	runErr := cmd.Run()
+	tracerx.Printf("creds: askpass for %s took %s", valueString, time.Since(started))
+	if runErr != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", errors.Errorf("askpass program %q did not respond within %s; see lfs.askpassTimeout", a.Program, a.Timeout)
+		}
+		return "", runErr
+	}
+
+	if err.Len() > 0 {
+		return "", errors.New(err.String())
+	}
+
+	return strings.TrimSpace(value.String()), nil
+}
+
+// prompt returns the prompt with which to ask for the given value, "Username"
+// or "Password", for the given URL.
+func (a *AskPassCredentialHelper) prompt(valueString string, u *url.URL) string {
+	if len(a.PromptTemplate) == 0 {
+		return fmt.Sprintf("%s for %q", valueString, u)
+	}
+	return strings.NewReplacer("%%", "%", "%s", u.String(), "%t", valueString).Replace(a.PromptTemplate)
+}
+
+// Name implements NamedCredentialHelper.Name.
+func (a *AskPassCredentialHelper) Name() string { return "askpass" }
+
+// Approve implements CredentialHelper.Approve, and returns nil. The ASKPASS
+// credential helper does not implement credential approval.
+func (a *AskPassCredentialHelper) Approve(_ Creds) error { return nil }
+
+// Reject implements CredentialHelper.Reject, and returns nil. The ASKPASS
+// credential helper does not implement credential rejection.
+func (a *AskPassCredentialHelper) Reject(_ Creds) error { return nil }
+
+// args returns the arguments given to the ASKPASS program, if a prompt was
+// given.
+
+// See: https://git-scm.com/docs/gitcredentials#_requesting_credentials for
+// more.
+func (a *AskPassCredentialHelper) args(prompt string) []string {
+	if len(prompt) == 0 {
+		return nil
+	}
+	return []string{prompt}
+}
+
+// parseSeconds parses a duration given either as a whole number of seconds, as
+// with other Git LFS timeouts, or with a unit, such as "90m".
+func parseSeconds(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// credentialInteractive is the value of "credential.interactive", which
+// controls whether credentials may be prompted for.
+type credentialInteractive int
+
+const (
+	// interactiveAuto prompts for credentials only if there is a terminal
+	// to prompt on. It is the default.
+	interactiveAuto credentialInteractive = iota
+
+	// interactiveNever never prompts for credentials, so they must be
+	// cached, stored, or given in the URL.
+	interactiveNever
+
+	// interactiveAlways prompts for credentials even if there is no
+	// terminal, as for scripts which answer prompts themselves.
+	interactiveAlways
+)
+
+// parseCredentialInteractive parses a "credential.interactive" value. As with
+// Git, a boolean is accepted: false is the same as "never", and true as
+// "auto". It returns false if the value is not valid.
+func parseCredentialInteractive(value string) (credentialInteractive, bool) {
+	switch strings.ToLower(value) {
+	case "auto", "true", "yes", "on", "1":
+		return interactiveAuto, true
+	case "never", "false", "no", "off", "0":
+		return interactiveNever, true
+	case "always":
+		return interactiveAlways, true
+	}
+	return interactiveAuto, false
+}
+
+// parseEnvAllowlist returns the names of the environment variables allowed by
+// the given "lfs.credentialhelperenv" entries, each of which may list several,
+// separated by commas or whitespace. The result is never nil, so that an empty
+// entry allows only PATH.
+func parseEnvAllowlist(entries []string) []string {
+	allowed := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		allowed = append(allowed, strings.FieldsFunc(entry, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})...)
+	}
+	return allowed
+}
+
+// credentialHelperEnv returns the environment in which a credential helper is
+// run: the whole environment of this process if allowed is nil, and otherwise
+// only PATH and the allowed variables, so that secrets in the environment are
+// not leaked to the helper.
+func credentialHelperEnv(allowed []string) []string {
+	env := os.Environ()
+	if allowed == nil {
+		return env
+	}
+
+	scrubbed := make([]string, 0, len(allowed)+1)
+	for _, kv := range env {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if envNameEqual(name, "PATH") {
+			scrubbed = append(scrubbed, kv)
+			continue
+		}
+		for _, a := range allowed {
+			if envNameEqual(name, a) {
+				scrubbed = append(scrubbed, kv)
+				break
+			}
+		}
+	}
+	return scrubbed
+}
+
+// envNameEqual returns whether the given environment variable names are the
+// same, which on Windows is regardless of case.
+func envNameEqual(a, b string) bool {
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// defaultLockedPattern matches the messages with which common credential
+// helpers report that the system keyring is locked.
+var defaultLockedPattern = regexp.MustCompile(`(?i)locked collection|unlock|(keyring|keychain)\b.*\blocked|user interaction is not allowed`)
+
+// defaultUnreachablePattern matches the messages with which credential helpers
+// report that a daemon they store credentials in, such as the one of 'git
+// credential-cache', could not be reached, as when its socket is stale.
+var defaultUnreachablePattern = regexp.MustCompile(`(?i)unable to connect to cache daemon|connection refused`)
+
+type commandCredentialHelper struct {
+	SkipPrompt bool
+
+	// LockedPattern matches the output of a credential helper on stderr
+	// indicating that it failed because the system keyring is locked.
+	LockedPattern *regexp.Regexp
+
+	// UnreachablePattern matches the output of a credential helper on
+	// stderr indicating that it failed because a daemon it depends on
+	// could not be reached.
+	UnreachablePattern *regexp.Regexp
+
+	// allowedEnv, if non-nil, are the only environment variables, besides
+	// PATH, with which 'git credential' is run, as given by
+	// "lfs.credentialhelperenv".
+	allowedEnv []string
+
+	// PromptOutput, if non-nil, receives the stderr of 'git credential'
+	// instead of os.Stderr. Its stdin is always used for the credential
+	// protocol, so cannot be redirected.
+	PromptOutput io.Writer
+
+	// gitVersion returns the version of the installed Git. If nil,
+	// git.Version is used.
+	gitVersion func() (string, error)
+
+	// noPrompt is the set of credential cache keys for which 'git
+	// credential fill' must not prompt, since they were handed off by a
+	// parent process.
+	noPrompt map[string]bool
+
+	// hasTerminal, if non-nil, returns whether Git could prompt for
+	// credentials. If it returns false, 'git credential fill' is run as
+	// with SkipPrompt, rather than risk blocking. It is only set if
+	// GIT_TERMINAL_PROMPT is not set explicitly.
+	hasTerminal     func() bool
+	hasTerminalOnce sync.Once
+	noTerminal      bool
+
+	// interactive is the value of "credential.interactive". With
+	// interactiveNever, 'git credential fill' never prompts, and with
+	// interactiveAlways, it may prompt even without a terminal.
+	interactive credentialInteractive
+
+	// approved maps the cache key of each credential approved by 'git
+	// credential approve' in this process to the approved Creds, so that
+	// identical approvals, as made by parallel transfers, are not passed
+	// on again. approvedMu is held for the duration of an approval, so
+	// that concurrent identical approvals are coalesced.
+	approved   map[string]Creds
+	approvedMu sync.Mutex
+
+	// maxOutput is the most output, in bytes, read from 'git credential'
+	// before it is abandoned, as given by
+	// "lfs.credentialhelpermaxoutput", or 0 if there is no limit.
+	maxOutput int64
+
+	lookPathOnce sync.Once
+	lookPathErr  error
+}
+
+// defaultCredentialHelperMaxOutput is the most output read from 'git
+// credential' if "lfs.credentialhelpermaxoutput" is not set. It is far more
+// than any token needs, but guards against a runaway helper.
+const defaultCredentialHelperMaxOutput = 16 * 1024 * 1024
+
+func (h *commandCredentialHelper) Name() string { return "git credential" }
+
+func (h *commandCredentialHelper) Fill(creds Creds) (Creds, error) {
+	return h.fill(creds, nil)
+}
+
+func (h *commandCredentialHelper) Reject(creds Creds) error {
+	return h.reject(creds, nil)
+}
+
+// Approve runs 'git credential approve' with the given Creds, unless identical
+// Creds were already approved successfully, and not since rejected.
+func (h *commandCredentialHelper) Approve(creds Creds) error {
+	return h.approve(creds, nil)
+}
+
+// fill, reject and approve run 'git credential' with the given
+// "credential.helper" entries in place of those Git would otherwise use, if
+// helpers is non-nil.
+func (h *commandCredentialHelper) fill(creds Creds, helpers []string) (Creds, error) {
+	tracerx.Printf("creds: git credential fill (%q, %q, %q)",
+		creds[CredsProtocol], creds[CredsHost], creds[CredsPath])
+	return h.exec("fill", creds, helpers)
+}
+
+func (h *commandCredentialHelper) reject(creds Creds, helpers []string) error {
+	// As 'git credential reject' erases the stored credentials matching
+	// each given field, forget the approvals of any which match, so that
+	// they are approved again.
+	h.approvedMu.Lock()
+	for key, approved := range h.approved {
+		if key == approvedKey(approved, helpers) && rejectionMatches(creds, approved) {
+			delete(h.approved, key)
+		}
+	}
+	h.approvedMu.Unlock()
+
+	_, err := h.exec("reject", creds, helpers)
+	return err
+}
+
+func (h *commandCredentialHelper) approve(creds Creds, helpers []string) error {
+	key := approvedKey(creds, helpers)
+
+	h.approvedMu.Lock()
+	defer h.approvedMu.Unlock()
+
+	if approved, ok := h.approved[key]; ok && credsEqual(approved, creds) {
+		tracerx.Printf("creds: skipping repeated git credential approve (%q, %q, %q)",
+			creds[CredsProtocol], creds[CredsHost], creds[CredsPath])
+		return nil
+	}
+
+	tracerx.Printf("creds: git credential approve (%q, %q, %q)",
+		creds[CredsProtocol], creds[CredsHost], creds[CredsPath])
+	if _, err := h.exec("approve", creds, helpers); err != nil {
+		return err
+	}
+
+	if h.approved == nil {
+		h.approved = make(map[string]Creds)
+	}
+	h.approved[key] = creds
+	return nil
+}
+
+// rejectionMatches returns whether the rejection of the given Creds "what"
+// applies to the given approved Creds: that is, whether each of the protocol,
+// host, path and username given in "what" matches.
+func rejectionMatches(what, approved Creds) bool {
+	for _, key := range []string{CredsProtocol, CredsHost, CredsPath, CredsUsername} {
+		if value := what[key]; len(value) > 0 && value != approved[key] {
+			return false
+		}
+	}
+	return true
+}
+
+// helperConfigArgs returns the arguments with which to make Git use the given
+// "credential.helper" entries in place of any it would otherwise use, or none
+// if helpers is nil. Since configuration given with "-c" is read last, the
+// empty entry clears all others, including those for a URL.
+func helperConfigArgs(helpers []string) []string {
+	if helpers == nil {
+		return nil
+	}
+
+	args := []string{"-c", "credential.helper="}
+	for _, helper := range helpers {
+		args = append(args, "-c", "credential.helper="+helper)
+	}
+	return args
+}
+
+// approvedKey returns the key under which the approval of the given Creds with
+// the given "credential.helper" entries is remembered.
+func approvedKey(creds Creds, helpers []string) string {
+	if helpers == nil {
+		return credCacheKey(creds)
+	}
+	return credCacheKey(creds) + "\n" + strings.Join(helpers, "\n")
+}
+
+// remoteCommandCredentialHelper runs 'git credential' with the
+// "remote.<name>.credentialHelper" entries of a remote, or the helper given by
+// GIT_LFS_CREDENTIAL_HELPER, in place of the "credential.helper" entries Git
+// would otherwise use.
+type remoteCommandCredentialHelper struct {
+	*commandCredentialHelper
+	helpers []string
+
+	// source is the configuration key or environment variable which gave
+	// the helpers.
+	source string
+}
+
+func (h *remoteCommandCredentialHelper) Fill(creds Creds) (Creds, error) {
+	return h.fill(creds, h.helpers)
+}
+
+func (h *remoteCommandCredentialHelper) Reject(creds Creds) error {
+	return h.reject(creds, h.helpers)
+}
+
+func (h *remoteCommandCredentialHelper) Approve(creds Creds) error {
+	return h.approve(creds, h.helpers)
+}
+
+// capabilitiesMinGitVersion is the first version of Git which understands
+// "capability[]" lines given to 'git credential'. Older versions must be given
+// only plain "key=value" lines.
+const capabilitiesMinGitVersion = "2.46.0"
+
+// capabilities returns the capabilities to advertise to 'git credential', or
+// none if the installed Git is too old to understand them.
+func (h *commandCredentialHelper) capabilities() []string {
+	version := h.gitVersion
+	if version == nil {
+		version = git.Version
+	}
+
+	v, err := version()
+	if err != nil {
+		tracerx.Printf("creds: error getting git version: %s", err)
+		return nil
+	}
+	if !git.IsVersionAtLeast(v, capabilitiesMinGitVersion) {
+		return nil
+	}
+	return []string{CredsAuthtype, "state"}
+}
+
+// withoutTerminal returns whether there is known to be no terminal on which Git
+// could prompt for credentials.
+func (h *commandCredentialHelper) withoutTerminal() bool {
+	if h.hasTerminal == nil {
+		return false
+	}
+	h.hasTerminalOnce.Do(func() {
+		h.noTerminal = !h.hasTerminal()
+	})
+	return h.noTerminal
+}
+
+// lookPath returns an error if the git executable cannot be found. The lookup
+// is only performed once for the lifetime of the helper.
+func (h *commandCredentialHelper) lookPath() error {
+	h.lookPathOnce.Do(func() {
+		if _, err := exec.LookPath("git"); err != nil {
+			h.lookPathErr = errors.Wrap(err, "git executable not found; required for credential storage")
+		}
+	})
+	return h.lookPathErr
+}
+
+func (h *commandCredentialHelper) exec(subcommand string, input Creds, helpers []string) (Creds, error) {
+	if err := h.lookPath(); err != nil {
+		return nil, err
+	}
+
+	output := &limitedBuffer{limit: h.maxOutput}
+	cmd := exec.Command("git", append(helperConfigArgs(helpers), "credential", subcommand)...)
+	cmd.Stdin = bufferCreds(input, h.capabilities()...)
+	cmd.Env = append(credentialHelperEnv(h.allowedEnv), credentialRecursionEnv+"=1")
+	skipPrompt := h.SkipPrompt
+	if subcommand == "fill" && h.noPrompt[credLookupKey(input)] {
+		tracerx.Printf("creds: credentials handed off by parent process, not prompting")
+		cmd.Env = append(cmd.Env, "GIT_TERMINAL_PROMPT=0")
+	} else if subcommand == "fill" && h.interactive == interactiveNever {
+		tracerx.Printf("creds: credential.interactive is %q, not prompting", "never")
+		cmd.Env = append(cmd.Env, "GIT_TERMINAL_PROMPT=0")
+		skipPrompt = true
+	} else if subcommand == "fill" && h.interactive != interactiveAlways && h.withoutTerminal() {
+		tracerx.Printf("creds: no terminal to prompt on, not prompting")
+		cmd.Env = append(cmd.Env, "GIT_TERMINAL_PROMPT=0")
+		skipPrompt = true
+	}
+	cmd.Stdout = output
+	/*
+	   There is a reason we don't read from stderr here:
+	   Git's credential cache daemon helper does not close its stderr, so if this
+	   process is the process that fires up the daemon, it will wait forever
+	   (until the daemon exits, really) trying to read from stderr.
+
+	   Instead, we simply pass it through to our stderr.
+
+	   See https://github.com/git-lfs/git-lfs/issues/117 for more details.
+
+	   We do keep a copy of what is written, through a pipe which we give to
+	   the process as a file, so that cmd.Wait() does not wait for the pipe
+	   to be closed.
+	*/
+	var out io.Writer = os.Stderr
+	if h.PromptOutput != nil {
+		out = h.PromptOutput
+	}
+	stderr, err := newStderrCapture(out)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stderr = stderr.w
+
+	// Slow credential helpers are a common cause of apparent hangs, so
+	// the time each takes is traced.
+	started := time.Now()
+	err = cmd.Start()
+	if err == nil {
+		err = cmd.Wait()
+	}
+	stderr.w.Close()
+	tracerx.Printf("creds: 'git credential %s' took %s", subcommand, time.Since(started))
+
+	// No error returned includes any of the input but its protocol and
+	// host, and any secret in it is redacted all the same.
+	if _, ok := err.(*exec.ExitError); ok {
+		if h.LockedPattern != nil && h.LockedPattern.MatchString(stderr.String()) {
+			return nil, errors.NewKeyringLockedError(fmt.Errorf("'git credential %s' error: %s", subcommand, redactSecrets(err.Error(), input)))
+		}
+		if h.UnreachablePattern != nil && h.UnreachablePattern.MatchString(stderr.String()) {
+			return nil, errors.NewCredentialHelperUnreachableError(fmt.Errorf("'git credential %s' error: %s", subcommand, redactSecrets(err.Error(), input)))
+		}
+
+		if skipPrompt && h.interactive == interactiveNever {
+			return nil, fmt.Errorf("change credential.interactive to be prompted to enter your credentials for %s://%s",
+				input[CredsProtocol], input[CredsHost])
+		}
+		if skipPrompt {
+			return nil, fmt.Errorf("change the GIT_TERMINAL_PROMPT env var to be prompted to enter your credentials for %s://%s",
+				input[CredsProtocol], input[CredsHost])
+		}
+
+		// 'git credential' exits with 128 if the helper doesn't fill the username
+		// and password values.
+		if subcommand == "fill" && err.Error() == "exit status 128" {
+			return nil, nil
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("'git credential %s' error: %s\n", subcommand, redactSecrets(err.Error(), input))
+	}
+
+	if output.exceeded {
+		zeroBytes(output.Bytes())
+		return nil, errors.Errorf("'git credential %s' output more than %d bytes; raise lfs.credentialHelperMaxOutput to allow this", subcommand, h.maxOutput)
+	}
+
+	creds := parseCreds(output.Bytes())
+	zeroBytes(output.Bytes())
+	return creds, nil
+}
+
+// limitedBuffer is a buffer which holds at most limit bytes, if limit is
+// positive. Anything written beyond that is discarded, rather than returning
+// an error, so that the process writing it is not left blocked on a full
+// pipe, and exceeded is set. It does not embed a bytes.Buffer, whose ReadFrom
+// io.Copy would use in place of Write.
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	limit    int64
+	exceeded bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.limit > 0 && int64(b.buf.Len())+int64(len(p)) > b.limit {
+		b.exceeded = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+// stderrCapture forwards the stderr of a child process to a writer, keeping a
+// copy of it. The child writes to w, the write end of an os.Pipe.
+type stderrCapture struct {
+	w    *os.File
+	done chan struct{}
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func newStderrCapture(out io.Writer) (*stderrCapture, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &stderrCapture{w: w, done: make(chan struct{})}
+	go func() {
+		defer close(c.done)
+		defer r.Close()
+		io.Copy(io.MultiWriter(out, c), r)
+	}()
+	return c, nil
+}
+
+func (c *stderrCapture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.Write(p)
+}
+
+// String returns what has been written to the pipe, once our copy of its write
+// end has been closed. It waits briefly for the remaining output, but not for
+// the pipe to be closed by any daemon which inherited it.
+func (c *stderrCapture) String() string {
+	select {
+	case <-c.done:
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.String()
+}
+
+// parseCreds parses the output of 'git credential', which consists of
+// "key=value" lines. Lines without a key or a value are ignored.
+func parseCreds(output []byte) Creds {
+	creds := make(Creds)
+	for _, line := range strings.Split(string(output), "\n") {
+		pieces := strings.SplitN(line, "=", 2)
+		if len(pieces) < 2 || len(pieces[0]) < 1 || len(pieces[1]) < 1 {
+			continue
+		}
+		pieces[0] = canonicalCredsKey(pieces[0])
+		if existing, ok := creds[pieces[0]]; ok && isMultiValuedKey(pieces[0]) {
+			creds[pieces[0]] = existing + "\n" + pieces[1]
+			continue
+		}
+		creds[pieces[0]] = pieces[1]
+	}
+	normalizePasswordExpiry(creds)
+
+	return creds
+}
+
+type credentialCacher struct {
+	creds map[string]Creds
+	mu    sync.Mutex
+
+	// unapproved is the set of keys of credentials which were promoted
+	// into the cache when filled, and have not yet been approved.
+	unapproved map[string]bool
+
+	// fills holds a lock for each key whose credentials are being filled
+	// by a promptOnceCredentialHelper.
+	fills   map[string]*sync.Mutex
+	fillsMu sync.Mutex
+
+	// maxEntries is the number of credentials which may be cached before
+	// the least recently used ones are evicted, or 0 if there is no limit.
+	maxEntries int
+
+	// used maps the key of each cached credential to the value of clock
+	// when it was last filled or stored.
+	used  map[string]uint64
+	clock uint64
+
+	// credKeys maps the key of each cached credential to its CredKey.
+	credKeys map[string]CredKey
+
+	// usernames maps the lookup key of each host, as given by
+	// credLookupKey, to the username last approved for it, for URLs with
+	// "credential.<url>.cacheUsernameOnly", whose passwords are not
+	// cached.
+	usernames map[string]string
+
+	// cacheBearerWithoutExpiry is false if credentials with an "authtype"
+	// of "Bearer" and no "password_expiry_utc" should not be cached, as
+	// they may be short-lived.
+	cacheBearerWithoutExpiry bool
+
+	// pathFallback is true if credentials cached without a "path" are
+	// filled for a "path" which has none cached.
+	pathFallback bool
+
+	// approveHostScope is true if credentials approved for a "path" are
+	// also cached without it, for the whole host, so that they are used
+	// for every path, and rejecting them removes both.
+	approveHostScope bool
+
+	// ttl is how long credentials are cached for, regardless of any
+	// "password_expiry_utc", or 0 if there is no limit. stored maps the
+	// key of each cached credential to the time it was cached.
+	ttl    time.Duration
+	stored map[string]time.Time
+
+	// jitter is the most by which the "password_expiry_utc" of cached
+	// credentials is brought forward, so that credentials which expire
+	// at the same time are not all filled again at once. jitters maps the
+	// key of each cached credential to the amount chosen for it.
+	jitter  time.Duration
+	jitters map[string]time.Duration
+}
+
+// randJitter returns a random duration between 0 and max, inclusive. It is a
+// variable so that tests may control the jitter applied to expiry times.
+var randJitter = func(max time.Duration) time.Duration {
+	return time.Duration(rand.Int63n(int64(max) + 1))
+}
+
+func NewCredentialCacher() *credentialCacher {
+	return &credentialCacher{
+		creds:      make(map[string]Creds),
+		unapproved: make(map[string]bool),
+		fills:      make(map[string]*sync.Mutex),
+		used:       make(map[string]uint64),
+		credKeys:   make(map[string]CredKey),
+		usernames:  make(map[string]string),
+		stored:     make(map[string]time.Time),
+		jitters:    make(map[string]time.Duration),
+
+		cacheBearerWithoutExpiry: true,
+	}
+}
+
+// CredKey identifies cached credentials, without revealing them. The username
+// and "authtype" fields are included, so that a host may have a credential
+// cached for each user and for each authentication scheme it accepts.
+type CredKey struct {
+	Protocol string
+	Host     string
+	Path     string
+	Username string
+	Authtype string
+
+	// Scope is CredsScopeProxy for the credentials of an HTTP proxy,
+	// and empty otherwise.
+	Scope string
+}
+
+// newCredKey returns the CredKey under which the given Creds are cached.
+func newCredKey(creds Creds) CredKey {
+	return CredKey{
+		Protocol: creds[CredsProtocol],
+		Host:     hostKey(creds[CredsHost]),
+		Path:     creds[CredsPath],
+		Username: creds[CredsUsername],
+		Authtype: creds[CredsAuthtype],
+		Scope:    creds[CredsScope],
+	}
+}
+
+// withoutUsername returns the CredKey with its username cleared.
+func (k CredKey) withoutUsername() CredKey {
+	k.Username = ""
+	return k
+}
+
+// String returns the CredKey in the form used to index cached credentials.
+// Each field is escaped, so that the separator cannot appear within one, and
+// no two different CredKeys have the same string form. The scope is only
+// appended if set, so that the keys of origin credentials are unchanged.
+func (k CredKey) String() string {
+	fields := []string{k.Protocol, k.Host, k.Path, k.Username, k.Authtype}
+	if len(k.Scope) > 0 {
+		fields = append(fields, k.Scope)
+	}
+	for i, field := range fields {
+		fields[i] = url.QueryEscape(field)
+	}
+	return strings.Join(fields, "/")
+}
+
+// credCacheKey returns the key under which the given Creds are cached.
+func credCacheKey(creds Creds) string {
+	return newCredKey(creds).String()
+}
+
+// credLookupKey returns the key of the given Creds without their username, for
+// matching requested Creds, which often have no username, against filled ones.
+// It is also used where usernames must not be revealed.
+func credLookupKey(creds Creds) string {
+	return newCredKey(creds).withoutUsername().String()
+}
+
+func (c *credentialCacher) Name() string { return "cache" }
+
+func (c *credentialCacher) Fill(what Creds) (Creds, error) {
+	if cached, ok := c.lookup(what); ok {
+		return cached, nil
+	}
+
+	if path, ok := what[CredsPath]; ok && len(path) > 0 && (c.pathFallback || c.approveHostScope) {
+		hostOnly := make(Creds, len(what))
+		for k, v := range what {
+			hostOnly[k] = v
+		}
+		delete(hostOnly, CredsPath)
+
+		if cached, ok := c.lookup(hostOnly); ok {
+			tracerx.Printf("creds: git credential cache fell back from path %q", path)
+			return cached, nil
+		}
+	}
+
+	return nil, credHelperNoOp
+}
+
+// find returns the key of the cached credentials for the given Creds. If they
+// have no username, the most recently used credentials for any username match.
+// It must only be called while c.mu is held.
+func (c *credentialCacher) find(what Creds) string {
+	key := credCacheKey(what)
+	if _, ok := c.creds[key]; ok || len(what[CredsUsername]) > 0 {
+		return key
+	}
+
+	want := newCredKey(what)
+	var found string
+	for k, credKey := range c.credKeys {
+		if credKey.withoutUsername() == want && (len(found) == 0 || c.used[k] > c.used[found]) {
+			found = k
+		}
+	}
+	if len(found) == 0 {
+		return key
+	}
+	return found
+}
+
+// peek returns a copy of the cached credentials for the given Creds, if any,
+// even if they have expired, without removing them or marking them used.
+func (c *credentialCacher) peek(what Creds) (Creds, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.creds[c.find(what)]
+	return copyCreds(cached), ok
+}
+
+// lookup returns the cached credentials for the given Creds, if any, removing
+// them if they have expired.
+func (c *credentialCacher) lookup(what Creds) (Creds, bool) {
+	c.mu.Lock()
+	key := c.find(what)
+	cached, ok := c.creds[key]
+	if ok && c.expired(key, timeNow()) {
+		tracerx.Printf("creds: git credential cache expired (%q, %q, %q)",
+			what[CredsProtocol], what[CredsHost], what[CredsPath])
+		c.remove(key)
+		ok = false
+	} else if ok {
+		c.touch(key)
+	}
+	c.mu.Unlock()
+
+	if ok {
+		tracerx.Printf("creds: git credential cache (%q, %q, %q)",
+			what[CredsProtocol], what[CredsHost], what[CredsPath])
+	}
+	return copyCreds(cached), ok
+}
+
+// has returns whether unexpired credentials are cached for the given Creds,
+// without marking them as used.
+func (c *credentialCacher) has(what Creds) bool {
+	return c.state(what) == CacheStateValid
+}
+
+// CacheState is the state of the credentials cached in memory for a URL, as
+// reported for diagnostics.
+type CacheState string
+
+const (
+	// CacheStateValid means credentials are cached, and are used as they
+	// are.
+	CacheStateValid CacheState = "valid"
+	// CacheStateExpired means credentials are cached, but have passed
+	// their "password_expiry_utc" or "lfs.credentialcachettl", so are
+	// filled again, and perhaps prompted for, when next needed.
+	CacheStateExpired CacheState = "expired"
+	// CacheStateAbsent means no credentials are cached.
+	CacheStateAbsent CacheState = "absent"
+)
+
+// state returns the state of the credentials cached for the given Creds,
+// without removing expired ones or marking them as used.
+func (c *credentialCacher) state(what Creds) CacheState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := c.find(what)
+	if _, ok := c.creds[key]; !ok {
+		return CacheStateAbsent
+	}
+	if c.expired(key, timeNow()) {
+		return CacheStateExpired
+	}
+	return CacheStateValid
+}
 
-	if len(username) > 0 {
-		// If a non-empty username was given, add it to the URL via func
-		// 'net/url.User()'.
-		u.User = url.User(creds["username"])
+// expired returns whether the cached credentials with the given key have
+// expired by the given time, either as their "password_expiry_utc" has passed,
+// or as they were cached longer ago than c.ttl. It must only be called while
+// c.mu is held.
+func (c *credentialCacher) expired(key string, now time.Time) bool {
+	if expiry, ok := c.creds[key].PasswordExpiry(); ok && !now.Before(expiry.Add(-c.jitters[key])) {
+		return true
 	}
+	stored, ok := c.stored[key]
+	return c.ttl > 0 && ok && now.Sub(stored) >= c.ttl
+}
 
-	password, err := a.getValue(what, credValueTypePassword, u)
-	if err != nil {
-		return nil, err
-	}
-	creds["password"] = password
+func (c *credentialCacher) Approve(what Creds) error {
+	key := credCacheKey(what)
 
-	return creds, nil
-}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-func (a *AskPassCredentialHelper) getValue(what Creds, valueType credValueType, u *url.URL) (string, error) {
-	var valueString string
+	// Credentials which are already cached have been approved, unless
+	// they were only promoted into the cache when filled, or have since
+	// changed, e.g. with a new "password_expiry_utc" or
+	// "oauth_refresh_token". Either way they must be passed on to be
+	// persisted.
+	if cached, ok := c.creds[key]; ok && !c.unapproved[key] && credsEqual(cached, what) {
+		return nil
+	}
 
-	switch valueType {
-	case credValueTypeUsername:
-		valueString = "username"
-	case credValueTypePassword:
-		valueString = "password"
-	default:
-		return "", errors.Errorf("Invalid Credential type queried from AskPass")
+	if !c.cacheable(what) {
+		return credHelperNoOp
 	}
 
-	// Return the existing credential if it was already provided, otherwise
-	// query AskPass for it
-	if given, ok := what[valueString]; ok {
-		return given, nil
+	c.store(key, what)
+	if hostOnly, ok := c.hostScoped(what); ok {
+		c.store(credCacheKey(hostOnly), hostOnly)
 	}
-	return a.getFromProgram(valueType, u)
+	c.evict()
+	return credHelperNoOp
 }
 
-func (a *AskPassCredentialHelper) getFromProgram(valueType credValueType, u *url.URL) (string, error) {
-	var (
-		value bytes.Buffer
-		err   bytes.Buffer
-
-		valueString string
-	)
+// store caches the given approved Creds under the given key. It must only be
+// called while c.mu is held.
+func (c *credentialCacher) store(key string, creds Creds) {
+	c.creds[key] = copyCreds(creds)
+	c.credKeys[key] = newCredKey(creds)
+	c.stored[key] = timeNow()
+	c.setJitter(key)
+	delete(c.unapproved, key)
+	c.touch(key)
+}
 
-	switch valueType {
-	case credValueTypeUsername:
-		valueString = "Username"
-	case credValueTypePassword:
-		valueString = "Password"
-	default:
-		return "", errors.Errorf("Invalid Credential type queried from AskPass")
+// setJitter chooses the amount by which the expiry of the credentials cached
+// under the given key is brought forward, if c.jitter is set. It must only be
+// called while c.mu is held.
+func (c *credentialCacher) setJitter(key string) {
+	if c.jitter > 0 {
+		c.jitters[key] = randJitter(c.jitter)
 	}
+}
 
-	// 'cmd' will run the GIT_ASKPASS (or core.askpass) command prompting
-	// for the desired valueType (`Username` or `Password`)
-	cmd := exec.Command(a.Program, a.args(fmt.Sprintf("%s for %q", valueString, u))...)
-	cmd.Stderr = &err
-	cmd.Stdout = &value
-
-	tracerx.Printf("creds: filling with GIT_ASKPASS: %s", strings.Join(cmd.Args, " "))
-	if err := cmd.Run(); err != nil {
-		return "", err
+// hostScoped returns the given Creds without their "path", and whether they
+// had one and "lfs.approvehostscope" is enabled, in which case credentials
+// approved for a path are also cached for the whole host.
+func (c *credentialCacher) hostScoped(creds Creds) (Creds, bool) {
+	if !c.approveHostScope || len(creds[CredsPath]) == 0 {
+		return nil, false
 	}
+	hostOnly := copyCreds(creds)
+	delete(hostOnly, CredsPath)
+	return hostOnly, true
+}
 
-	if err.Len() > 0 {
-		return "", errors.New(err.String())
+// cacheable returns whether the given credentials may be cached.
+func (c *credentialCacher) cacheable(creds Creds) bool {
+	if c.cacheBearerWithoutExpiry || !strings.EqualFold(creds[CredsAuthtype], "Bearer") {
+		return true
+	}
+	if _, ok := creds[CredsPasswordExpiryUTC]; ok {
+		return true
 	}
 
-	return strings.TrimSpace(value.String()), nil
+	tracerx.Printf("creds: not caching bearer token without expiry (%q, %q, %q)",
+		creds[CredsProtocol], creds[CredsHost], creds[CredsPath])
+	return false
 }
 
-// Approve implements CredentialHelper.Approve, and returns nil. The ASKPASS
-// credential helper does not implement credential approval.
-func (a *AskPassCredentialHelper) Approve(_ Creds) error { return nil }
+func credsEqual(a, b Creds) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
 
-// Reject implements CredentialHelper.Reject, and returns nil. The ASKPASS
-// credential helper does not implement credential rejection.
-func (a *AskPassCredentialHelper) Reject(_ Creds) error { return nil }
+func (c *credentialCacher) Reject(what Creds) error {
+	c.mu.Lock()
+	c.reject(what)
+	if hostOnly, ok := c.hostScoped(what); ok {
+		c.reject(hostOnly)
+	} else if c.approveHostScope && len(what[CredsPath]) == 0 {
+		c.rejectPaths(what)
+	}
+	c.mu.Unlock()
+	return credHelperNoOp
+}
 
-// args returns the arguments given to the ASKPASS program, if a prompt was
-// given.
+// rejectPaths removes the credentials cached for any path of the host of the
+// given Creds, which have none, if they are the same credentials, since those
+// cached for the host were also cached for the path they were approved for. It
+// must only be called while c.mu is held.
+func (c *credentialCacher) rejectPaths(what Creds) {
+	want := newCredKey(what)
+	for key, credKey := range c.credKeys {
+		if len(credKey.Path) == 0 {
+			continue
+		}
+		credKey.Path = ""
+		if len(want.Username) == 0 {
+			credKey.Username = ""
+		}
+		if credKey == want && sameSecret(c.creds[key], what) {
+			c.remove(key)
+		}
+	}
+}
 
-// See: https://git-scm.com/docs/gitcredentials#_requesting_credentials for
-// more.
-func (a *AskPassCredentialHelper) args(prompt string) []string {
-	if len(prompt) == 0 {
-		return nil
+// sameSecret returns whether the cached Creds have the same password and
+// credential as the given ones, where those are given.
+func sameSecret(cached, what Creds) bool {
+	for _, key := range []string{CredsPassword, CredsCredential} {
+		if value, ok := what[key]; ok && len(value) > 0 && cached[key] != value {
+			return false
+		}
 	}
-	return []string{prompt}
+	return true
 }
 
-type commandCredentialHelper struct {
-	SkipPrompt bool
+// reject removes the cached credentials matching the given Creds. It must only
+// be called while c.mu is held.
+func (c *credentialCacher) reject(what Creds) {
+	c.remove(credCacheKey(what))
+	if len(what[CredsUsername]) == 0 {
+		// Without a username, the credentials for any username match.
+		want := newCredKey(what)
+		for key, credKey := range c.credKeys {
+			if credKey.withoutUsername() == want {
+				c.remove(key)
+			}
+		}
+	}
 }
 
-func (h *commandCredentialHelper) Fill(creds Creds) (Creds, error) {
-	tracerx.Printf("creds: git credential fill (%q, %q, %q)",
-		creds["protocol"], creds["host"], creds["path"])
-	return h.exec("fill", creds)
+// rejectHost removes the credentials cached for the given protocol and host,
+// for any path, username, "authtype" or scope.
+func (c *credentialCacher) rejectHost(protocol, host string) {
+	c.mu.Lock()
+	for key, credKey := range c.credKeys {
+		if credKey.Protocol == protocol && strings.EqualFold(credKey.Host, hostKey(host)) {
+			c.remove(key)
+		}
+	}
+	c.mu.Unlock()
 }
 
-func (h *commandCredentialHelper) Reject(creds Creds) error {
-	_, err := h.exec("reject", creds)
-	return err
+// touch marks the credentials with the given key as the most recently used. It
+// must only be called while c.mu is held.
+func (c *credentialCacher) touch(key string) {
+	c.clock++
+	c.used[key] = c.clock
 }
 
-func (h *commandCredentialHelper) Approve(creds Creds) error {
-	tracerx.Printf("creds: git credential approve (%q, %q, %q)",
-		creds["protocol"], creds["host"], creds["path"])
-	_, err := h.exec("approve", creds)
-	return err
+// remove removes the credentials with the given key from the cache. It must
+// only be called while c.mu is held.
+func (c *credentialCacher) remove(key string) {
+	delete(c.creds, key)
+	delete(c.unapproved, key)
+	delete(c.used, key)
+	delete(c.credKeys, key)
+	delete(c.stored, key)
+	delete(c.jitters, key)
 }
 
-func (h *commandCredentialHelper) exec(subcommand string, input Creds) (Creds, error) {
-	output := new(bytes.Buffer)
-	cmd := exec.Command("git", "credential", subcommand)
-	cmd.Stdin = bufferCreds(input)
-	cmd.Stdout = output
-	/*
-	   There is a reason we don't read from stderr here:
-	   Git's credential cache daemon helper does not close its stderr, so if this
-	   process is the process that fires up the daemon, it will wait forever
-	   (until the daemon exits, really) trying to read from stderr.
+// evict removes the least recently used credentials until no more than
+// c.maxEntries remain. It must only be called while c.mu is held.
+func (c *credentialCacher) evict() {
+	if c.maxEntries <= 0 {
+		return
+	}
 
-	   Instead, we simply pass it through to our stderr.
+	for len(c.creds) > c.maxEntries {
+		var oldest string
+		var oldestUsed uint64
+		for key := range c.creds {
+			if used := c.used[key]; len(oldest) == 0 || used < oldestUsed {
+				oldest, oldestUsed = key, used
+			}
+		}
 
-	   See https://github.com/git-lfs/git-lfs/issues/117 for more details.
-	*/
-	cmd.Stderr = os.Stderr
+		tracerx.Printf("creds: evicting %q from git credential cache", oldest)
+		c.remove(oldest)
+	}
+}
 
-	err := cmd.Start()
-	if err == nil {
-		err = cmd.Wait()
+// promote caches the given credentials, filled for the Creds "what", before
+// they have been approved, so that they are not filled again, while a later Approve is
+// still passed on to the other credential helpers.
+func (c *credentialCacher) promote(what, creds Creds) {
+	credKey := newCredKey(what)
+	if len(credKey.Username) == 0 {
+		credKey.Username = creds[CredsUsername]
 	}
+	key := credKey.String()
 
-	if _, ok := err.(*exec.ExitError); ok {
-		if h.SkipPrompt {
-			return nil, fmt.Errorf("change the GIT_TERMINAL_PROMPT env var to be prompted to enter your credentials for %s://%s",
-				input["protocol"], input["host"])
-		}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-		// 'git credential' exits with 128 if the helper doesn't fill the username
-		// and password values.
-		if subcommand == "fill" && err.Error() == "exit status 128" {
-			return nil, nil
-		}
+	if !c.cacheable(creds) {
+		return
 	}
+	c.creds[key] = copyCreds(creds)
+	c.credKeys[key] = credKey
+	c.stored[key] = timeNow()
+	c.setJitter(key)
+	c.unapproved[key] = true
+	c.touch(key)
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("'git credential %s' error: %s\n", subcommand, err.Error())
+// lockFill locks the credentials with the given key for filling, returning a
+// function which unlocks them.
+func (c *credentialCacher) lockFill(key string) func() {
+	c.fillsMu.Lock()
+	mu, ok := c.fills[key]
+	if !ok {
+		mu = new(sync.Mutex)
+		c.fills[key] = mu
 	}
+	c.fillsMu.Unlock()
 
-	creds := make(Creds)
-	for _, line := range strings.Split(output.String(), "\n") {
-		pieces := strings.SplitN(line, "=", 2)
-		if len(pieces) < 2 || len(pieces[1]) < 1 {
-			continue
+	mu.Lock()
+	return mu.Unlock
+}
+
+// keys returns the lookup keys, without usernames, of all cached credentials.
+func (c *credentialCacher) keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seen := make(map[string]bool, len(c.credKeys))
+	keys := make([]string, 0, len(c.credKeys))
+	for _, credKey := range c.credKeys {
+		key := credKey.withoutUsername().String()
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
 		}
-		creds[pieces[0]] = pieces[1]
 	}
+	return keys
+}
 
-	return creds, nil
+// Snapshot returns the keys of all cached credentials, sorted, for diagnostics.
+// The credentials themselves are never included.
+func (c *credentialCacher) Snapshot() []CredKey {
+	c.mu.Lock()
+	snapshot := make([]CredKey, 0, len(c.credKeys))
+	for _, k := range c.credKeys {
+		snapshot = append(snapshot, k)
+	}
+	c.mu.Unlock()
+
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].String() < snapshot[j].String()
+	})
+	return snapshot
 }
 
-type credentialCacher struct {
-	creds map[string]Creds
-	mu    sync.Mutex
+// Flush removes all cached credentials.
+func (c *credentialCacher) Flush() {
+	c.mu.Lock()
+	c.creds = make(map[string]Creds)
+	c.unapproved = make(map[string]bool)
+	c.used = make(map[string]uint64)
+	c.credKeys = make(map[string]CredKey)
+	c.usernames = make(map[string]string)
+	c.stored = make(map[string]time.Time)
+	c.jitters = make(map[string]time.Duration)
+	c.mu.Unlock()
 }
 
-func NewCredentialCacher() *credentialCacher {
-	return &credentialCacher{creds: make(map[string]Creds)}
+// username returns the username cached for the host of the given Creds by
+// approveUsername, if any.
+func (c *credentialCacher) username(what Creds) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	username, ok := c.usernames[credLookupKey(what)]
+	return username, ok
 }
 
-func credCacheKey(creds Creds) string {
-	parts := []string{
-		creds["protocol"],
-		creds["host"],
-		creds["path"],
+// approveUsername caches the given approved username, without its password,
+// for the host of the given requested Creds.
+func (c *credentialCacher) approveUsername(what Creds, username string) {
+	if len(username) == 0 {
+		return
 	}
-	return strings.Join(parts, "//")
+	c.mu.Lock()
+	c.usernames[credLookupKey(what)] = username
+	c.mu.Unlock()
 }
 
-func (c *credentialCacher) Fill(what Creds) (Creds, error) {
-	key := credCacheKey(what)
+// rejectUsername removes the username cached for the host of the given
+// requested Creds, if it is the given rejected one.
+func (c *credentialCacher) rejectUsername(what Creds, username string) {
+	key := credLookupKey(what)
 	c.mu.Lock()
-	cached, ok := c.creds[key]
+	if c.usernames[key] == username {
+		delete(c.usernames, key)
+	}
 	c.mu.Unlock()
+}
 
-	if ok {
-		tracerx.Printf("creds: git credential cache (%q, %q, %q)",
-			what["protocol"], what["host"], what["path"])
-		return cached, nil
-	}
+// promptOnceCredentialHelper wraps a CredentialHelper which may prompt the
+// user, promoting the credentials it fills into a credentialCacher. This
+// ensures the user is prompted at most once per host, even if the configured
+// credential helper does not store anything, and even if several fills for the
+// same host happen at once.
+type promptOnceCredentialHelper struct {
+	CredentialHelper
+	cache *credentialCacher
+}
 
-	return nil, credHelperNoOp
+func (h *promptOnceCredentialHelper) Name() string {
+	return credentialHelperName(h.CredentialHelper)
 }
 
-func (c *credentialCacher) Approve(what Creds) error {
+func (h *promptOnceCredentialHelper) Fill(what Creds) (Creds, error) {
 	key := credCacheKey(what)
+	defer h.cache.lockFill(key)()
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	if _, ok := c.creds[key]; ok {
-		return nil
+	// Incomplete cached credentials, as merged by
+	// "lfs.credentialmergepartial", are completed by the helper instead.
+	if cached, err := h.cache.Fill(what); err == nil && ValidateCreds(mergeCreds(what, cached)) == nil {
+		return cached, nil
 	}
 
-	c.creds[key] = what
-	return credHelperNoOp
-}
-
-func (c *credentialCacher) Reject(what Creds) error {
-	key := credCacheKey(what)
-	c.mu.Lock()
-	delete(c.creds, key)
-	c.mu.Unlock()
-	return credHelperNoOp
+	creds, err := h.CredentialHelper.Fill(what)
+	if err == nil && len(creds) > 0 {
+		h.cache.promote(what, creds)
+	}
+	return creds, err
 }
 
 // CredentialHelpers iterates through a slice of CredentialHelper objects
@@ -405,15 +3250,57 @@ func (c *credentialCacher) Reject(what Creds) error {
 //
 // A CredentialHelper can return a credHelperNoOp error, signaling that the
 // CredentialHelpers should try the next one.
+//
+// A CredentialHelpers is safe for concurrent use by multiple goroutines, as
+// when objects are transferred in parallel, provided that its credential
+// helpers are, as all of those in this package are. Each call to Fill, Approve
+// or Reject works on a snapshot of the helpers taken under s.mu when it
+// starts, and consults the skip list as it reaches each helper, so a helper
+// skipped by one call is not consulted by the rest of a concurrent one.
 type CredentialHelpers struct {
+	// helpers and skippedHelpers are guarded by mu.
 	helpers        []CredentialHelper
 	skippedHelpers map[int]bool
 	mu             sync.Mutex
+
+	// requestedUsername, if non-empty, is the username given in the URL
+	// or by "credential.<url>.username", which takes precedence over any
+	// filled by a credential helper. Creds filled for another username
+	// are discarded, so that the next credential helper fills, or prompts
+	// for, those of the requested one, rather than pairing the requested
+	// username with another user's password.
+	requestedUsername string
+
+	// strictMatch is true if filled Creds whose "protocol" or "host"
+	// differ from the requested ones are discarded.
+	strictMatch bool
+
+	// mergePartial is true if, when a credential helper fills Creds
+	// missing fields required by their "authtype", such as a password
+	// without a username, the next helpers are asked for the missing
+	// fields, and their results merged.
+	mergePartial bool
+
+	// onReject, if non-nil, is called with each rejected Creds.
+	onReject func(Creds)
+
+	// onApprove, if non-nil, is called with each approved Creds, even if
+	// no credential helper stores them.
+	onApprove func(Creds)
+
+	// approveEvent and rejectEvent, if non-nil, are given a
+	// CredentialEvent once each approval or rejection is complete.
+	approveEvent func(CredentialEvent)
+	rejectEvent  func(CredentialEvent)
 }
 
 // NewCredentialHelpers initializes a new CredentialHelpers from the given
 // slice of CredentialHelper instances.
 func NewCredentialHelpers(helpers []CredentialHelper) CredentialHelper {
+	return newCredentialHelpers(helpers)
+}
+
+func newCredentialHelpers(helpers []CredentialHelper) *CredentialHelpers {
 	return &CredentialHelpers{
 		helpers:        helpers,
 		skippedHelpers: make(map[int]bool),
@@ -428,31 +3315,72 @@ var credHelperNoOp = errors.New("no-op!")
 // If a fill was successful, it is returned immediately, and no other
 // `CredentialHelper`s are consulted. If any CredentialHelper returns an error,
 // it is reported to tracerx, and the next one is attempted. If they all error,
-// then a collection of all the error messages is returned. Erroring credential
-// helpers are added to the skip list, and never attempted again for the
-// lifetime of the current Git LFS command.
+// then a collection of all the error messages is returned, one per line, each
+// prefixed with the name of the CredentialHelper which returned it. Erroring
+// credential helpers are added to the skip list, and never attempted again for
+// the lifetime of the current Git LFS command, unless the error is a
+// CredentialHelperUnreachableError, as the daemon the helper depends on may be
+// reachable again later. Credential helpers that do not support the requested
+// protocol are not consulted.
 func (s *CredentialHelpers) Fill(what Creds) (Creds, error) {
-	errs := make([]string, 0, len(s.helpers))
-	for i, h := range s.helpers {
+	helpers := s.snapshot()
+	errs := make([]string, 0, len(helpers))
+	input := what
+	var partial Creds
+	for i, h := range helpers {
 		if s.skipped(i) {
 			continue
 		}
 
-		creds, err := h.Fill(what)
+		if !supportsProtocol(h, what[CredsProtocol]) {
+			continue
+		}
+
+		creds, err := h.Fill(input)
 		if err != nil {
-			if err != credHelperNoOp {
+			if errors.IsCredentialHelperUnreachableError(err) {
+				tracerx.Printf("creds: credential helper %d (%s) is unreachable, asking the next: %s", i, credentialHelperName(h), err)
+				errs = append(errs, fmt.Sprintf("%s: %s", credentialHelperName(h), err))
+			} else if err != credHelperNoOp {
 				s.skip(i)
-				tracerx.Printf("credential fill error: %s", err)
-				errs = append(errs, err.Error())
+				tracerx.Printf("credential fill error: %s: %s", credentialHelperName(h), err)
+				errs = append(errs, fmt.Sprintf("%s: %s", credentialHelperName(h), err))
 			}
 			continue
 		}
 
 		if creds != nil {
-			return creds, nil
+			if s.strictMatch && !credsMatch(what, creds) {
+				tracerx.Printf("creds: ignoring credentials for (%q, %q) filled for (%q, %q)",
+					creds[CredsProtocol], creds[CredsHost], what[CredsProtocol], what[CredsHost])
+				continue
+			}
+			if filled := creds[CredsUsername]; len(s.requestedUsername) > 0 && len(filled) > 0 && filled != s.requestedUsername {
+				tracerx.Printf("creds: ignoring credentials for username %q filled by credential helper %d (%s), since %q was requested; asking the next",
+					filled, i, credentialHelperName(h), s.requestedUsername)
+				continue
+			}
+			if partial != nil {
+				creds = mergePartialCreds(partial, creds)
+			}
+			if s.mergePartial {
+				if err := ValidateCreds(mergeCreds(what, creds)); err != nil {
+					tracerx.Printf("creds: incomplete credentials filled by credential helper %d (%s), asking the next: %s", i, credentialHelperName(h), err)
+					partial = creds
+					input = partialInput(what, creds)
+					continue
+				}
+			}
+			tracerx.Printf("creds: filled by credential helper %d (%s)", i, credentialHelperName(h))
+			return mergeCreds(what, creds), nil
 		}
 	}
 
+	if partial != nil {
+		tracerx.Printf("creds: no credential helper completed the incomplete credentials")
+		return mergeCreds(what, partial), nil
+	}
+
 	if len(errs) > 0 {
 		return nil, errors.New("credential fill errors:\n" + strings.Join(errs, "\n"))
 	}
@@ -460,10 +3388,88 @@ func (s *CredentialHelpers) Fill(what Creds) (Creds, error) {
 	return nil, nil
 }
 
+// mergeCreds returns the Creds filled for the requested Creds "what". The
+// requested username, scope and "authtype", if any, are kept when the filled
+// Creds have none, since credential helpers which do not understand them do
+// not return them. A filled username is never replaced, so that it stays
+// paired with its password: a requested one which takes precedence over it is
+// enforced by CredentialHelpers discarding Creds filled for another username.
+func mergeCreds(what, filled Creds) Creds {
+	var keep []string
+	for _, key := range []string{CredsUsername, CredsScope, CredsAuthtype} {
+		if len(what[key]) > 0 && len(filled[key]) == 0 {
+			keep = append(keep, key)
+		}
+	}
+
+	if len(keep) == 0 {
+		return filled
+	}
+
+	merged := make(Creds, len(filled)+len(keep))
+	for k, v := range filled {
+		merged[k] = v
+	}
+	for _, key := range keep {
+		merged[key] = what[key]
+	}
+	return merged
+}
+
+// mergePartialCreds returns the Creds filled by a credential helper after
+// another filled the incomplete Creds "partial", whose fields take precedence.
+func mergePartialCreds(partial, filled Creds) Creds {
+	merged := copyCreds(filled)
+	for k, v := range partial {
+		if len(v) > 0 {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// partialInput returns the Creds with which to ask the next credential helper
+// to complete the incomplete Creds "partial" filled for the requested Creds
+// "what": those requested, with the username or password filled so far.
+func partialInput(what, partial Creds) Creds {
+	input := copyCreds(what)
+	for _, key := range []string{CredsUsername, CredsPassword} {
+		if len(input[key]) == 0 && len(partial[key]) > 0 {
+			input[key] = partial[key]
+		}
+	}
+	return input
+}
+
+// credsMatch returns whether the "protocol" and "host" of the filled Creds, if
+// present, match those of the requested Creds.
+func credsMatch(what, filled Creds) bool {
+	if protocol, ok := filled[CredsProtocol]; ok && !strings.EqualFold(protocol, what[CredsProtocol]) {
+		return false
+	}
+	if host, ok := filled[CredsHost]; ok && !strings.EqualFold(hostKey(host), hostKey(what[CredsHost])) {
+		return false
+	}
+	return true
+}
+
 // Reject implements CredentialHelper.Reject and rejects the given Creds "what"
 // with the first successful attempt.
 func (s *CredentialHelpers) Reject(what Creds) error {
-	for i, h := range s.helpers {
+	if s.onReject != nil {
+		s.onReject(what)
+	}
+
+	err := s.reject(what)
+	if s.rejectEvent != nil {
+		s.rejectEvent(newCredentialEvent(what, err))
+	}
+	return err
+}
+
+func (s *CredentialHelpers) reject(what Creds) error {
+	helpers := s.snapshot()
+	for i, h := range helpers {
 		if s.skipped(i) {
 			continue
 		}
@@ -476,14 +3482,52 @@ func (s *CredentialHelpers) Reject(what Creds) error {
 	return errors.New("no valid credential helpers to reject")
 }
 
+// rejectAll rejects the given Creds "what" with every CredentialHelper which
+// has not been skipped, rather than only the first which handles them,
+// returning the errors of any which fail.
+func (s *CredentialHelpers) rejectAll(what Creds) error {
+	var errs []string
+	helpers := s.snapshot()
+	for i, h := range helpers {
+		if s.skipped(i) {
+			continue
+		}
+
+		if err := h.Reject(what); err != nil && err != credHelperNoOp {
+			tracerx.Printf("credential reject error: %s", err)
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New("credential reject errors:\n" + strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
 // Approve implements CredentialHelper.Approve and approves the given Creds
 // "what" with the first successful CredentialHelper. If an error occurrs,
 // it calls Reject() with the same Creds and returns the error immediately. This
 // ensures a caching credential helper removes the cache, since the Erroring
-// CredentialHelper never successfully saved it.
+// CredentialHelper never successfully saved it. The rollback does not depend on
+// a cache being present, as with "lfs.cachecredentials" disabled: every
+// earlier helper which was not skipped is asked to reject the Creds.
 func (s *CredentialHelpers) Approve(what Creds) error {
+	if s.onApprove != nil {
+		s.onApprove(what)
+	}
+
+	err := s.approve(what)
+	if s.approveEvent != nil {
+		s.approveEvent(newCredentialEvent(what, err))
+	}
+	return err
+}
+
+func (s *CredentialHelpers) approve(what Creds) error {
 	skipped := make(map[int]bool)
-	for i, h := range s.helpers {
+	helpers := s.snapshot()
+	for i, h := range helpers {
 		if s.skipped(i) {
 			skipped[i] = true
 			continue
@@ -493,9 +3537,14 @@ func (s *CredentialHelpers) Approve(what Creds) error {
 			if err != nil && i > 0 { // clear any cached approvals
 				for j := 0; j < i; j++ {
 					if !skipped[j] {
-						s.helpers[j].Reject(what)
+						helpers[j].Reject(what)
 					}
 				}
+				if s.rejectEvent != nil {
+					event := newCredentialEvent(what, err)
+					event.Rollback = true
+					s.rejectEvent(event)
+				}
 			}
 			return err
 		}
@@ -504,6 +3553,24 @@ func (s *CredentialHelpers) Approve(what Creds) error {
 	return errors.New("no valid credential helpers to approve")
 }
 
+// Reset clears the skip list, so that credential helpers which returned an
+// error are consulted again. A long-lived CredentialHelpers may be Reset
+// between independent operations, so that a transient failure does not
+// disable a helper permanently. A single Git LFS command need not call it.
+func (s *CredentialHelpers) Reset() {
+	s.mu.Lock()
+	s.skippedHelpers = make(map[int]bool)
+	s.mu.Unlock()
+}
+
+// snapshot returns the credential helpers, in order. The returned slice is
+// not modified after it is returned, so may be used without holding s.mu.
+func (s *CredentialHelpers) snapshot() []CredentialHelper {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]CredentialHelper(nil), s.helpers...)
+}
+
 func (s *CredentialHelpers) skip(i int) {
 	s.mu.Lock()
 	s.skippedHelpers[i] = true
@@ -524,6 +3591,10 @@ var (
 	NullCreds     = &nullCredentialHelper{}
 )
 
+func (h *nullCredentialHelper) Name() string {
+	return "null"
+}
+
 func (h *nullCredentialHelper) Fill(input Creds) (Creds, error) {
 	return nil, nullCredError
 }
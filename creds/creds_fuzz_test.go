@@ -0,0 +1,40 @@
+// +build go1.18
+
+package creds
+
+import (
+	"strings"
+	"testing"
+)
+
+func FuzzParseCredOutput(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"\n\n\n",
+		"protocol=https\nhost=example.com\nusername=foo\npassword=bar\n",
+		"username=\npassword=bar",
+		"=value\n",
+		"novalue\n",
+		"password=a=b==c\n",
+		"username=foo\r\npassword=bar\r\n",
+		"host=exämple.com\npassword=пароль\n",
+		"\x00=\x00\n",
+		"state[]=a\nstate[]=b\n",
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, output []byte) {
+		for key, value := range parseCreds(output) {
+			if len(key) == 0 || len(value) == 0 {
+				t.Fatalf("empty key or value: %q=%q", key, value)
+			}
+			if strings.ContainsAny(key, "=\n") {
+				t.Fatalf("invalid key: %q", key)
+			}
+			if strings.Contains(value, "\n") && !isMultiValuedKey(key) {
+				t.Fatalf("invalid value: %q", value)
+			}
+		}
+	})
+}
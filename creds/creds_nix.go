@@ -2,4 +2,30 @@
 
 package creds
 
+import (
+	"os"
+
+	isatty "github.com/mattn/go-isatty"
+)
+
 var netrcBasename = ".netrc"
+
+// defaultBuiltinCredentialHelper is the built-in credential helper used when
+// neither "lfs.credentialhelper" nor "credential.helper" is configured.
+var defaultBuiltinCredentialHelper = ""
+
+// hasTerminal returns whether Git could prompt for credentials: whether
+// standard input is a terminal, or the process has a controlling terminal,
+// which Git prompts on even if standard input is redirected, as in a hook.
+func hasTerminal() bool {
+	if isatty.IsTerminal(os.Stdin.Fd()) {
+		return true
+	}
+
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return false
+	}
+	tty.Close()
+	return true
+}
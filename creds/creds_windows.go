@@ -2,4 +2,31 @@
 
 package creds
 
+import (
+	"os"
+
+	isatty "github.com/mattn/go-isatty"
+)
+
 var netrcBasename = "_netrc"
+
+// defaultBuiltinCredentialHelper is the built-in credential helper used when
+// neither "lfs.credentialhelper" nor "credential.helper" is configured.
+var defaultBuiltinCredentialHelper = "wincred"
+
+// hasTerminal returns whether Git could prompt for credentials: whether
+// standard input is a terminal, or the process is attached to a console,
+// which Git prompts on even if standard input is redirected, as in a hook.
+func hasTerminal() bool {
+	fd := os.Stdin.Fd()
+	if isatty.IsTerminal(fd) || isatty.IsCygwinTerminal(fd) {
+		return true
+	}
+
+	console, err := os.Open("CONIN$")
+	if err != nil {
+		return false
+	}
+	console.Close()
+	return true
+}
@@ -0,0 +1,211 @@
+package creds
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// CredentialDescription describes how credentials for a URL would be filled,
+// for diagnostics. It never includes the credentials themselves.
+type CredentialDescription struct {
+	URL      string `json:"url"`
+	Protocol string `json:"protocol"`
+	Host     string `json:"host"`
+	Path     string `json:"path,omitempty"`
+
+	// Cached is true if valid credentials for the URL are cached in
+	// memory.
+	Cached bool `json:"cached"`
+
+	// CacheState is whether credentials for the URL are cached and valid,
+	// cached but expired, or absent, or empty if caching is disabled. An
+	// expired entry explains why credentials are prompted for again.
+	CacheState CacheState `json:"cache_state,omitempty"`
+
+	// Refused is the reason no credentials would be filled for the URL,
+	// if any.
+	Refused string `json:"refused,omitempty"`
+
+	// Helpers are the credential helpers which would be consulted, in
+	// order.
+	Helpers []CredentialHelperDescription `json:"helpers"`
+}
+
+// CredentialHelperDescription describes one credential helper in the chain
+// consulted for a URL.
+type CredentialHelperDescription struct {
+	Order int    `json:"order"`
+	Name  string `json:"name"`
+
+	// Source is the environment variable or configuration key which
+	// enabled the credential helper.
+	Source string `json:"source"`
+
+	// ReadOnly is true if approvals and rejections are not passed on to
+	// the credential helper, as "lfs.credentialsreadonly" is enabled.
+	ReadOnly bool `json:"read_only"`
+
+	// Helpers are the "credential.helper" entries 'git credential' would
+	// use for the URL, for the "git credential" helper only. Entries which
+	// are shell commands are given as "!...".
+	Helpers []string `json:"helpers,omitempty"`
+}
+
+// Describe returns a human-readable description of the credential helpers
+// which would be consulted for the given URL, in order, and whether
+// credentials for it are cached, and if so, whether they have expired. No
+// credentials are included.
+func (ctxt *CredentialHelperContext) Describe(u *url.URL) string {
+	d := ctxt.describe(u)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "credentials for %s", d.URL)
+	switch d.CacheState {
+	case CacheStateValid:
+		b.WriteString(" (cached)")
+	case CacheStateExpired:
+		b.WriteString(" (cached, expired)")
+	}
+	b.WriteString("\n")
+	if len(d.Refused) > 0 {
+		fmt.Fprintf(&b, "  refused: %s\n", d.Refused)
+	}
+	for _, h := range d.Helpers {
+		fmt.Fprintf(&b, "  %d: %s (%s)", h.Order, h.Name, h.Source)
+		if len(h.Helpers) > 0 {
+			fmt.Fprintf(&b, " %s", strings.Join(h.Helpers, ", "))
+		}
+		if h.ReadOnly {
+			b.WriteString(" read-only")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// DescribeJSON returns the description given by Describe as JSON, for tools.
+// It has the structure of a CredentialDescription.
+func (ctxt *CredentialHelperContext) DescribeJSON(u *url.URL) ([]byte, error) {
+	return json.Marshal(ctxt.describe(u))
+}
+
+func (ctxt *CredentialHelperContext) describe(u *url.URL) *CredentialDescription {
+	wrapper := ctxt.peekCredentialHelper(u)
+	d := &CredentialDescription{
+		URL:      SanitizeURL(u),
+		Protocol: wrapper.Input[CredsProtocol],
+		Host:     wrapper.Input[CredsHost],
+		Path:     wrapper.Input[CredsPath],
+		Helpers:  make([]CredentialHelperDescription, 0),
+	}
+	if ctxt.cachingCredHelper != nil {
+		d.CacheState = ctxt.cachingCredHelper.state(wrapper.Input)
+		d.Cached = d.CacheState == CacheStateValid
+	}
+
+	helpers, refused := chainedHelpers(wrapper)
+	if refused != nil {
+		d.Refused = refused.Error()
+	}
+	for i, h := range helpers {
+		d.Helpers = append(d.Helpers, ctxt.describeHelper(i, h, wrapper.Url))
+	}
+	return d
+}
+
+// chainedHelpers returns the credential helpers which the given wrapper would
+// consult, in order, or the error with which it refuses to fill credentials.
+func chainedHelpers(wrapper CredentialHelperWrapper) ([]CredentialHelper, error) {
+	switch h := wrapper.CredentialHelper.(type) {
+	case *refusedCredentialHelper:
+		return nil, h.err
+	case *CredentialHelpers:
+		return h.snapshot(), nil
+	default:
+		return []CredentialHelper{h}, nil
+	}
+}
+
+// unwrapCredentialHelper returns the CredentialHelper wrapped by the given one,
+// if it only changes when it is consulted, and whether it is read-only.
+func unwrapCredentialHelper(h CredentialHelper) (CredentialHelper, bool) {
+	var readOnly bool
+	for {
+		if ro, ok := h.(*readOnlyCredentialHelper); ok {
+			readOnly = true
+			h = ro.CredentialHelper
+		} else if na, ok := h.(*noApproveCredentialHelper); ok {
+			h = na.CredentialHelper
+		} else if po, ok := h.(*promptOnceCredentialHelper); ok {
+			h = po.CredentialHelper
+		} else {
+			return h, readOnly
+		}
+	}
+}
+
+// describeHelper describes the given CredentialHelper, which is consulted at
+// the given position in the chain for the given URL.
+func (ctxt *CredentialHelperContext) describeHelper(order int, h CredentialHelper, u *url.URL) CredentialHelperDescription {
+	d := CredentialHelperDescription{Order: order, Name: credentialHelperName(h)}
+	h, d.ReadOnly = unwrapCredentialHelper(h)
+
+	switch h {
+	case ctxt.builtinCredHelper:
+		d.Source = "lfs.credentialhelper"
+		if ctxt.builtinCredHelperAuto {
+			d.Source = "default"
+		}
+		return d
+	case ctxt.negotiateCredHelper:
+		d.Source = "lfs.negotiatecredentialhelper"
+		return d
+	}
+
+	switch h := h.(type) {
+	case *netrcCredentialHelper:
+		d.Source = "netrc"
+	case *credentialCacher:
+		d.Source = "lfs.cachecredentials"
+	case *oauthRefreshCredentialHelper:
+		d.Source = "credential.tokenendpoint"
+	case *vaultCredentialHelper:
+		d.Source = "VAULT_ADDR"
+	case *BrokerCredentialHelper:
+		d.Source = "credential.brokerendpoint"
+	case *ProcessCredentialHelper:
+		d.Source = "lfs.credentialhelperprogram"
+	case *TerminalCredentialHelper:
+		d.Source = "lfs.terminalprompt"
+	case *stdinCredentialHelper:
+		d.Source = "lfs.credentialfromstdin"
+	case *AskPassCredentialHelper:
+		d.Source = ctxt.askpassSource
+	case *commandCredentialHelper:
+		d.Source = "credential.helper"
+		credsURL := ctxt.groupURL(ctxt.unaliasURL(u))
+		d.Helpers = describeHelperEntries(ctxt.credentialHelpers(fmt.Sprintf("%s://%s%s", credsURL.Scheme, credsURL.Host, credsURL.Path)))
+	case *remoteCommandCredentialHelper:
+		d.Source = h.source
+		d.Helpers = describeHelperEntries(h.helpers)
+	default:
+		d.Source = "unknown"
+	}
+	return d
+}
+
+// describeHelperEntries returns the given "credential.helper" entries as they
+// are described.
+func describeHelperEntries(entries []string) []string {
+	var helpers []string
+	for _, helper := range entries {
+		// A shell command may embed a secret, so is not described.
+		if strings.HasPrefix(helper, "!") {
+			helper = "!..."
+		}
+		helpers = append(helpers, helper)
+	}
+	return helpers
+}
@@ -0,0 +1,163 @@
+package creds
+
+import (
+	"net/http"
+	"net/url"
+	"os/exec"
+
+	"github.com/git-lfs/git-lfs/errors"
+	"github.com/git-lfs/git-lfs/git"
+)
+
+// DiagnoseResult reports whether each credential helper which would be
+// consulted for a URL is usable, for troubleshooting authentication failures.
+type DiagnoseResult struct {
+	URL string `json:"url"`
+
+	// Refused is the reason no credentials would be filled for the URL,
+	// if any.
+	Refused string `json:"refused,omitempty"`
+
+	// Helpers are the credential helpers which would be consulted, in
+	// order.
+	Helpers []HelperDiagnosis `json:"helpers"`
+}
+
+// OK returns whether credentials would be filled for the URL, and every
+// credential helper is usable.
+func (r DiagnoseResult) OK() bool {
+	if len(r.Refused) > 0 {
+		return false
+	}
+	for _, h := range r.Helpers {
+		if !h.OK() {
+			return false
+		}
+	}
+	return true
+}
+
+// HelperDiagnosis reports whether one credential helper is usable.
+type HelperDiagnosis struct {
+	CredentialHelperDescription
+
+	// Problem is the reason the credential helper is not usable, if any.
+	Problem string `json:"problem,omitempty"`
+}
+
+// OK returns whether the credential helper is usable.
+func (d HelperDiagnosis) OK() bool {
+	return len(d.Problem) == 0
+}
+
+// diagnosableCredentialHelper is implemented by credential helpers which can
+// check whether they are usable, without filling, approving or rejecting any
+// credentials.
+type diagnosableCredentialHelper interface {
+	diagnose() error
+}
+
+// Diagnose checks whether each credential helper which would be consulted for
+// the given URL is usable: for example, that Git is installed for 'git
+// credential', and that the askpass program exists. No credentials are filled,
+// and no credential helper is asked for any.
+func (ctxt *CredentialHelperContext) Diagnose(u *url.URL) DiagnoseResult {
+	wrapper := ctxt.peekCredentialHelper(u)
+	r := DiagnoseResult{
+		URL:     SanitizeURL(u),
+		Helpers: make([]HelperDiagnosis, 0),
+	}
+
+	helpers, refused := chainedHelpers(wrapper)
+	if refused != nil {
+		r.Refused = refused.Error()
+	}
+	for i, h := range helpers {
+		d := HelperDiagnosis{CredentialHelperDescription: ctxt.describeHelper(i, h, wrapper.Url)}
+		h, _ = unwrapCredentialHelper(h)
+		if dh, ok := h.(diagnosableCredentialHelper); ok {
+			if err := dh.diagnose(); err != nil {
+				d.Problem = err.Error()
+			}
+		}
+		r.Helpers = append(r.Helpers, d)
+	}
+	return r
+}
+
+// diagnose checks that Git, which runs the configured credential helpers, is
+// installed, and reports its version.
+func (h *commandCredentialHelper) diagnose() error {
+	if err := h.lookPath(); err != nil {
+		return err
+	}
+
+	version := h.gitVersion
+	if version == nil {
+		version = git.Version
+	}
+	if _, err := version(); err != nil {
+		return errors.Wrap(err, "git version")
+	}
+	return nil
+}
+
+// diagnose checks that the askpass program exists.
+func (a *AskPassCredentialHelper) diagnose() error {
+	if _, err := exec.LookPath(a.Program); err != nil {
+		return errors.Wrapf(err, "askpass program %q not found", a.Program)
+	}
+	return nil
+}
+
+// diagnose checks that the credential helper program exists.
+func (h *ProcessCredentialHelper) diagnose() error {
+	if _, err := exec.LookPath(h.Program); err != nil {
+		return errors.Wrapf(err, "credential helper program %q not found", h.Program)
+	}
+	return nil
+}
+
+// diagnose checks that standard input, from which the credential is read, is
+// not a terminal.
+func (h *stdinCredentialHelper) diagnose() error {
+	if h.isTerminal != nil && h.isTerminal() {
+		return errors.New("lfs.credentialFromStdin is set, but standard input is a terminal")
+	}
+	return nil
+}
+
+// diagnose checks that there is a terminal to prompt on.
+func (h *TerminalCredentialHelper) diagnose() error {
+	open := h.openTerminal
+	if open == nil {
+		open = openControllingTerminal
+	}
+	in, out, err := open()
+	if err != nil {
+		return errors.Wrap(err, "lfs.terminalPrompt is set, but there is no terminal")
+	}
+	in.Close()
+	if out != in {
+		out.Close()
+	}
+	return nil
+}
+
+// diagnose checks that the Vault server is reachable, initialized and
+// unsealed, with its health endpoint, which requires no token.
+func (h *vaultCredentialHelper) diagnose() error {
+	res, err := h.do("GET", "sys/health", nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusTooManyRequests, 472, 473:
+		// Active, standby, disaster recovery secondary, or performance
+		// standby, all of which may serve reads.
+		return nil
+	}
+	return errors.Errorf("vault: server at %s is not healthy: HTTP %d", h.addr, res.StatusCode)
+}
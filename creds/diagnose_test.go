@@ -0,0 +1,159 @@
+package creds
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/git-lfs/git-lfs/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// diagnosisProblems returns the problem reported for each credential helper in
+// the given DiagnoseResult, by name.
+func diagnosisProblems(r DiagnoseResult) map[string]string {
+	problems := make(map[string]string)
+	for _, h := range r.Helpers {
+		problems[h.Name] = h.Problem
+	}
+	return problems
+}
+
+func TestCredentialHelperContextDiagnose(t *testing.T) {
+	defer fakeGit(t, "", 0)()
+
+	dir := os.Getenv("PATH")
+	askpass := filepath.Join(dir, "askpass")
+	program := filepath.Join(dir, "git-credential-fake")
+	for _, path := range []string{askpass, program} {
+		require.Nil(t, ioutil.WriteFile(path, []byte("#!/bin/sh\n"), 0755))
+	}
+
+	ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"lfs.credentialhelperprogram": []string{program},
+	})), config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"GIT_ASKPASS": []string{askpass},
+	})))
+	ctxt.netrcCredHelper = nil
+	ctxt.builtinCredHelper = nil
+	ctxt.commandCredHelper.gitVersion = func() (string, error) { return "git version 2.30.0", nil }
+
+	u, _ := url.Parse("https://example.com/repo.git")
+	r := ctxt.Diagnose(u)
+	assert.True(t, r.OK())
+	assert.Equal(t, "https://example.com/repo.git", r.URL)
+	assert.Equal(t, map[string]string{
+		"cache":          "",
+		"process":        "",
+		"askpass":        "",
+		"git credential": "",
+	}, diagnosisProblems(r))
+}
+
+func TestCredentialHelperContextDiagnoseProblems(t *testing.T) {
+	defer fakeGit(t, "", 0)()
+
+	dir := os.Getenv("PATH")
+	require.Nil(t, os.Remove(filepath.Join(dir, "git")))
+
+	ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"lfs.credentialhelperprogram": []string{filepath.Join(dir, "git-credential-missing")},
+	})), config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"GIT_ASKPASS": []string{filepath.Join(dir, "missing-askpass")},
+	})))
+	ctxt.netrcCredHelper = nil
+	ctxt.builtinCredHelper = nil
+
+	u, _ := url.Parse("https://example.com/repo.git")
+	r := ctxt.Diagnose(u)
+	assert.False(t, r.OK())
+
+	problems := diagnosisProblems(r)
+	assert.Equal(t, "", problems["cache"])
+	assert.Contains(t, problems["process"], "git-credential-missing")
+	assert.Contains(t, problems["askpass"], "missing-askpass")
+	assert.Contains(t, problems["git credential"], "git executable not found")
+}
+
+func TestCredentialHelperContextDiagnoseGitVersion(t *testing.T) {
+	defer fakeGit(t, "", 0)()
+
+	ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(nil)),
+		config.EnvironmentOf(config.MapFetcher(nil)))
+	ctxt.netrcCredHelper = nil
+	ctxt.builtinCredHelper = nil
+	ctxt.commandCredHelper.gitVersion = func() (string, error) { return "", errors.New("broken") }
+
+	u, _ := url.Parse("https://example.com/repo.git")
+	problems := diagnosisProblems(ctxt.Diagnose(u))
+	assert.Contains(t, problems["git credential"], "broken")
+}
+
+func TestCredentialHelperContextDiagnoseVault(t *testing.T) {
+	defer fakeGit(t, "", 0)()
+
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/sys/health", r.URL.Path)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(nil)), config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"VAULT_ADDR":  []string{srv.URL},
+		"VAULT_TOKEN": []string{"root-token"},
+	})))
+	ctxt.netrcCredHelper = nil
+	ctxt.builtinCredHelper = nil
+	ctxt.commandCredHelper.gitVersion = func() (string, error) { return "git version 2.30.0", nil }
+
+	u, _ := url.Parse("https://example.com/repo.git")
+	assert.Equal(t, "", diagnosisProblems(ctxt.Diagnose(u))["vault"])
+
+	// a sealed server is not usable
+	status = http.StatusServiceUnavailable
+	assert.Contains(t, diagnosisProblems(ctxt.Diagnose(u))["vault"], "HTTP 503")
+
+	srv.Close()
+	assert.NotEqual(t, "", diagnosisProblems(ctxt.Diagnose(u))["vault"])
+}
+
+func TestCredentialHelperContextDiagnoseStdin(t *testing.T) {
+	ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(nil)),
+		config.EnvironmentOf(config.MapFetcher(nil)))
+	ctxt.netrcCredHelper = nil
+	ctxt.builtinCredHelper = nil
+	ctxt.stdinCredHelper = &stdinCredentialHelper{
+		in:         strings.NewReader(""),
+		isTerminal: func() bool { return true },
+	}
+
+	u, _ := url.Parse("https://example.com/repo.git")
+	r := ctxt.Diagnose(u)
+	assert.False(t, r.OK())
+	assert.Contains(t, diagnosisProblems(r)["stdin"], "terminal")
+
+	ctxt.stdinCredHelper.isTerminal = func() bool { return false }
+	assert.True(t, ctxt.Diagnose(u).OK())
+}
+
+func TestCredentialHelperContextDiagnoseRefused(t *testing.T) {
+	ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"lfs.credentialallowinsecure": []string{"false"},
+	})), config.EnvironmentOf(config.MapFetcher(nil)))
+	ctxt.netrcCredHelper = nil
+	ctxt.builtinCredHelper = nil
+
+	u, _ := url.Parse("http://example.com/repo.git")
+	r := ctxt.Diagnose(u)
+	assert.False(t, r.OK())
+	assert.Contains(t, r.Refused, "insecure protocol")
+	assert.Empty(t, r.Helpers)
+}
@@ -0,0 +1,57 @@
+package creds
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// CredentialHandoffEnv is the environment variable through which a Git LFS
+// process tells the Git LFS processes it runs, such as filters run by the Git
+// commands it runs, which credentials it has already filled.
+//
+// Only credential cache keys (protocol, host, path and authtype) are handed
+// off, never usernames, passwords or other secrets, since the environment of
+// a process is readable by other processes of the same user and is often
+// logged. A child which finds a key in the handoff does not prompt for that
+// credential, on the basis that its parent already did so and approved the
+// result with the configured credential helper.
+const CredentialHandoffEnv = "GIT_LFS_CREDENTIAL_HANDOFF"
+
+// encodeCredentialHandoff encodes the given credential cache keys as the value
+// of the CredentialHandoffEnv environment variable.
+func encodeCredentialHandoff(keys []string) string {
+	escaped := make([]string, 0, len(keys))
+	for _, key := range keys {
+		escaped = append(escaped, url.QueryEscape(key))
+	}
+	sort.Strings(escaped)
+	return strings.Join(escaped, ",")
+}
+
+// decodeCredentialHandoff decodes the value of the CredentialHandoffEnv
+// environment variable into a set of credential cache keys. Malformed entries
+// are ignored.
+func decodeCredentialHandoff(value string) map[string]bool {
+	keys := make(map[string]bool)
+	for _, escaped := range strings.Split(value, ",") {
+		if len(escaped) == 0 {
+			continue
+		}
+		if key, err := url.QueryUnescape(escaped); err == nil {
+			keys[key] = true
+		}
+	}
+	return keys
+}
+
+// HandoffEnv returns the value of the CredentialHandoffEnv environment
+// variable for child processes, listing the credentials cached by this
+// context, or the empty string if "lfs.credentialhandoff" is disabled or
+// caching is turned off.
+func (ctxt *CredentialHelperContext) HandoffEnv() string {
+	if !ctxt.handoff || ctxt.cachingCredHelper == nil {
+		return ""
+	}
+	return encodeCredentialHandoff(ctxt.cachingCredHelper.keys())
+}
@@ -0,0 +1,90 @@
+package creds
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/git-lfs/git-lfs/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentialHandoffEncoding(t *testing.T) {
+	keys := []string{
+		"https/example.com///",
+		"https/example.com/repo%2Cwith%2Ccommas.git//",
+		"http/host%3A8080/path//Bearer",
+	}
+
+	encoded := encodeCredentialHandoff(keys)
+	assert.NotContains(t, encoded, "/")
+
+	decoded := decodeCredentialHandoff(encoded)
+	assert.Equal(t, len(keys), len(decoded))
+	for _, key := range keys {
+		assert.True(t, decoded[key], key)
+	}
+
+	assert.Empty(t, decodeCredentialHandoff(""))
+	assert.Equal(t, map[string]bool{"a": true}, decodeCredentialHandoff("a,,%zz"))
+}
+
+func TestCredentialHandoffEnv(t *testing.T) {
+	gitEnv := config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"lfs.credentialhandoff": []string{"true"},
+	}))
+	parent := NewCredentialHelperContext(gitEnv, config.EnvironmentOf(config.MapFetcher(nil)))
+
+	creds := Creds{"protocol": "https", "host": "example.com", "username": "foo", "password": "secret"}
+	parent.cachingCredHelper.Approve(creds)
+
+	value := parent.HandoffEnv()
+	assert.Equal(t, "https%2Fexample.com%2F%2F%2F", value)
+	assert.NotContains(t, value, "secret")
+	assert.NotContains(t, value, "foo")
+
+	child := NewCredentialHelperContext(gitEnv, config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		CredentialHandoffEnv: []string{value},
+	})))
+	assert.True(t, child.commandCredHelper.noPrompt[credLookupKey(creds)])
+
+	disabled := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(nil)), config.EnvironmentOf(config.MapFetcher(nil)))
+	assert.Equal(t, "", disabled.HandoffEnv())
+}
+
+func TestCredentialHandoffChildSkipsPrompt(t *testing.T) {
+	defer fakeGit(t, "", 0)()
+
+	// 'git credential' logs whether it may prompt, and fills no
+	// credentials
+	dir := os.Getenv("PATH")
+	logged := filepath.Join(dir, "prompt")
+	script := fmt.Sprintf("#!/bin/sh\necho \"${GIT_TERMINAL_PROMPT-1}\" >> %q\nwhile read line; do :; done\nexit 128\n", logged)
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "git"), []byte(script), 0755))
+
+	ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"lfs.credentialhandoff":  []string{"true"},
+		"credential.interactive": []string{"always"},
+	})), config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		CredentialHandoffEnv: []string{"https%2Fexample.com%2F%2F%2F"},
+	})))
+	ctxt.netrcCredHelper = nil
+	ctxt.builtinCredHelper = nil
+	ctxt.askpassCredHelper = nil
+	ctxt.commandCredHelper.gitVersion = func() (string, error) { return "git version 2.30.0", nil }
+
+	for _, rawurl := range []string{"https://example.com/repo.git", "https://other.example.com/repo.git"} {
+		u, _ := url.Parse(rawurl)
+		wrapper := ctxt.GetCredentialHelper(nil, u)
+		wrapper.FillCreds()
+	}
+
+	// only the credentials handed off by the parent are not prompted for
+	by, err := ioutil.ReadFile(logged)
+	require.Nil(t, err)
+	assert.Equal(t, "0\n1\n", string(by))
+}
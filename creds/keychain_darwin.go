@@ -0,0 +1,160 @@
+// +build darwin
+
+package creds
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/git-lfs/git-lfs/errors"
+	"github.com/rubyist/tracerx"
+)
+
+// keychainItemNotFound is the exit status of the security(1) program when the
+// requested keychain item does not exist.
+const keychainItemNotFound = 44
+
+// KeychainCredentialHelper implements the CredentialHelper type by storing
+// credentials as generic passwords in the macOS login keychain, using the
+// security(1) program.
+type KeychainCredentialHelper struct {
+	// Program is the name of the security(1) program.
+	Program string
+}
+
+func newKeychainCredentialHelper() CredentialHelper {
+	return &KeychainCredentialHelper{Program: "security"}
+}
+
+// keychainService returns the keychain service name under which the given
+// Creds are stored.
+func keychainService(what Creds) string {
+	return "git-lfs:" + credLookupKey(what)
+}
+
+// Name implements NamedCredentialHelper.Name.
+func (h *KeychainCredentialHelper) Name() string { return "osxkeychain" }
+
+// Fill implements CredentialHelper.Fill by reading a generic password from the
+// keychain. It returns credHelperNoOp if no matching item exists.
+func (h *KeychainCredentialHelper) Fill(what Creds) (Creds, error) {
+	service := keychainService(what)
+
+	username, ok := what[CredsUsername]
+	if !ok {
+		out, err := h.run(nil, "find-generic-password", "-s", service)
+		if err != nil {
+			return nil, err
+		}
+		username = parseKeychainAccount(out)
+	}
+
+	out, err := h.run(nil, "find-generic-password", "-s", service, "-a", username, "-w")
+	if err != nil {
+		return nil, err
+	}
+
+	tracerx.Printf("keychain: git credential fill (%q, %q, %q)",
+		what[CredsProtocol], what[CredsHost], what[CredsPath])
+
+	creds := make(Creds)
+	for k, v := range what {
+		creds[k] = v
+	}
+	creds[CredsUsername] = username
+	creds[CredsPassword] = strings.TrimSuffix(string(out), "\n")
+	return creds, nil
+}
+
+// Approve implements CredentialHelper.Approve by adding or updating a generic
+// password in the keychain. The command is given to security(1) on its
+// standard input, so the password does not appear in the process list.
+func (h *KeychainCredentialHelper) Approve(what Creds) error {
+	password := what[CredsPassword]
+	if len(password) == 0 {
+		return credHelperNoOp
+	}
+
+	tracerx.Printf("keychain: git credential approve (%q, %q, %q)",
+		what[CredsProtocol], what[CredsHost], what[CredsPath])
+
+	command := fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+		keychainQuote(keychainService(what)),
+		keychainQuote(what[CredsUsername]),
+		keychainQuote(password))
+
+	_, err := h.run(strings.NewReader(command), "-i")
+	return err
+}
+
+// Reject implements CredentialHelper.Reject by deleting the generic password
+// from the keychain, if one is present.
+func (h *KeychainCredentialHelper) Reject(what Creds) error {
+	tracerx.Printf("keychain: git credential reject (%q, %q, %q)",
+		what[CredsProtocol], what[CredsHost], what[CredsPath])
+
+	args := []string{"delete-generic-password", "-s", keychainService(what)}
+	if username, ok := what[CredsUsername]; ok {
+		args = append(args, "-a", username)
+	}
+
+	if _, err := h.run(nil, args...); err != nil && err != credHelperNoOp {
+		return err
+	}
+	return nil
+}
+
+// run runs the security(1) program with the given arguments and standard
+// input, returning its standard output. It returns credHelperNoOp if the
+// program reports that the keychain item was not found.
+func (h *KeychainCredentialHelper) run(stdin *strings.Reader, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(h.Program, args...)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ProcessState.ExitCode() == keychainItemNotFound {
+			return nil, credHelperNoOp
+		}
+		return nil, errors.Wrap(err, fmt.Sprintf("keychain: %s", strings.TrimSpace(stderr.String())))
+	}
+	return stdout.Bytes(), nil
+}
+
+// parseKeychainAccount returns the "acct" attribute from the output of
+// 'security find-generic-password', which looks like:
+//
+//   attributes:
+//       "acct"<blob>="username"
+func parseKeychainAccount(out []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, `"acct"<blob>=`) {
+			continue
+		}
+
+		value := strings.TrimPrefix(line, `"acct"<blob>=`)
+		if value == "<NULL>" {
+			return ""
+		}
+		return strings.TrimSuffix(strings.TrimPrefix(value, `"`), `"`)
+	}
+	return ""
+}
+
+// keychainQuote quotes s as a single argument for the interactive mode of
+// security(1).
+func keychainQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
@@ -0,0 +1,60 @@
+// +build darwin
+
+package creds
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKeychainAccount(t *testing.T) {
+	out := []byte(`keychain: "/Users/me/Library/Keychains/login.keychain-db"
+version: 512
+class: "genp"
+attributes:
+    0x00000007 <blob>="git-lfs:https/example.com///"
+    "acct"<blob>="user"
+    "svce"<blob>="git-lfs:https/example.com///"
+`)
+	assert.Equal(t, "user", parseKeychainAccount(out))
+	assert.Equal(t, "", parseKeychainAccount([]byte(`    "acct"<blob>=<NULL>`)))
+	assert.Equal(t, "", parseKeychainAccount(nil))
+}
+
+func TestKeychainQuote(t *testing.T) {
+	assert.Equal(t, `"pass"`, keychainQuote("pass"))
+	assert.Equal(t, `"pa\"s\\s"`, keychainQuote(`pa"s\s`))
+}
+
+func TestKeychainCredentialHelper(t *testing.T) {
+	helper := newKeychainCredentialHelper()
+	what := Creds{
+		CredsProtocol: "https",
+		CredsHost:     "keychain-test.git-lfs.invalid",
+		CredsPath:     "repo.git",
+	}
+
+	approved := Creds{
+		CredsProtocol: "https",
+		CredsHost:     "keychain-test.git-lfs.invalid",
+		CredsPath:     "repo.git",
+		CredsUsername: "user",
+		CredsPassword: "pass",
+	}
+	if err := helper.Approve(approved); err != nil {
+		t.Skipf("keychain unavailable: %s", err)
+	}
+	defer helper.Reject(what)
+
+	out, err := helper.Fill(what)
+	assert.Nil(t, err)
+	assert.Equal(t, approved, out)
+
+	assert.Nil(t, helper.Reject(what))
+	_, err = helper.Fill(what)
+	assert.Equal(t, credHelperNoOp, err)
+
+	// rejecting a missing credential is not an error
+	assert.Nil(t, helper.Reject(what))
+}
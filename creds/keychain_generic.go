@@ -0,0 +1,7 @@
+// +build !darwin
+
+package creds
+
+func newKeychainCredentialHelper() CredentialHelper {
+	return nil
+}
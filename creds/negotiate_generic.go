@@ -0,0 +1,7 @@
+// +build !linux
+
+package creds
+
+func newNegotiateCredentialHelper() CredentialHelper {
+	return nil
+}
@@ -0,0 +1,75 @@
+package creds
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	spnego "github.com/dpotapov/go-spnego"
+	"github.com/rubyist/tracerx"
+)
+
+// negotiateAuthtype is the "authtype" of credentials filled by the
+// NegotiateCredentialHelper.
+const negotiateAuthtype = "Negotiate"
+
+// NegotiateCredentialHelper is a CredentialHelper which fills credentials for
+// SPNEGO ("Negotiate") authentication from the user's Kerberos ticket cache,
+// without prompting. It is only consulted when the server challenges with
+// "Negotiate".
+type NegotiateCredentialHelper struct {
+	provider spnego.Provider
+}
+
+func newNegotiateCredentialHelper() CredentialHelper {
+	return &NegotiateCredentialHelper{provider: spnego.New()}
+}
+
+func (h *NegotiateCredentialHelper) Name() string { return "negotiate" }
+
+// Fill implements CredentialHelper.Fill, returning a credential with an
+// "authtype" of "Negotiate" whose "credential" is an SPNEGO token for the
+// requested host, or credHelperNoOp if there is no Kerberos ticket to make one
+// from.
+func (h *NegotiateCredentialHelper) Fill(what Creds) (Creds, error) {
+	u := &url.URL{Scheme: what[CredsProtocol], Host: what[CredsHost], Path: "/"}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without a Kerberos ticket, the next credential helper is consulted
+	// instead.
+	if err := h.provider.SetSPNEGOHeader(req); err != nil {
+		tracerx.Printf("creds: negotiate: no token for %s: %s", what[CredsHost], err)
+		return nil, credHelperNoOp
+	}
+
+	pieces := strings.SplitN(req.Header.Get("Authorization"), " ", 2)
+	if len(pieces) != 2 || !strings.EqualFold(pieces[0], negotiateAuthtype) {
+		tracerx.Printf("creds: negotiate: no token for %s", what[CredsHost])
+		return nil, credHelperNoOp
+	}
+
+	return Creds{
+		CredsProtocol:   what[CredsProtocol],
+		CredsHost:       what[CredsHost],
+		CredsAuthtype:   negotiateAuthtype,
+		CredsCredential: pieces[1],
+	}, nil
+}
+
+// Approve implements CredentialHelper.Approve. Negotiate tokens are single-use,
+// so they are never stored, nor passed on to be stored by other credential
+// helpers. Other credentials are passed on.
+func (h *NegotiateCredentialHelper) Approve(what Creds) error {
+	if strings.EqualFold(what[CredsAuthtype], negotiateAuthtype) {
+		return nil
+	}
+	return credHelperNoOp
+}
+
+// Reject implements CredentialHelper.Reject in the same way as Approve.
+func (h *NegotiateCredentialHelper) Reject(what Creds) error {
+	return h.Approve(what)
+}
@@ -0,0 +1,64 @@
+package creds
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSPNEGOProvider struct {
+	err error
+}
+
+func (p *fakeSPNEGOProvider) SetSPNEGOHeader(req *http.Request) error {
+	if p.err != nil {
+		return p.err
+	}
+	req.Header.Set("Authorization", "Negotiate token-for-"+req.URL.Host)
+	return nil
+}
+
+func TestNegotiateCredentialHelperFill(t *testing.T) {
+	helper := &NegotiateCredentialHelper{provider: &fakeSPNEGOProvider{}}
+
+	creds, err := helper.Fill(Creds{"protocol": "https", "host": "example.com", "username": "foo"})
+	assert.Nil(t, err)
+	assert.Equal(t, Creds{
+		"protocol":   "https",
+		"host":       "example.com",
+		"authtype":   "Negotiate",
+		"credential": "token-for-example.com",
+	}, creds)
+
+	assert.Nil(t, helper.Approve(creds))
+	assert.Nil(t, helper.Reject(creds))
+	assert.Equal(t, credHelperNoOp, helper.Approve(Creds{"username": "foo", "password": "bar"}))
+	assert.Equal(t, credHelperNoOp, helper.Reject(Creds{"username": "foo", "password": "bar"}))
+}
+
+func TestNegotiateCredentialHelperNoTicket(t *testing.T) {
+	helper := &NegotiateCredentialHelper{provider: &fakeSPNEGOProvider{err: errors.New("no ticket")}}
+
+	_, err := helper.Fill(Creds{"protocol": "https", "host": "example.com"})
+	assert.Equal(t, credHelperNoOp, err)
+}
+
+func TestNegotiateCredentialHelperKerberos(t *testing.T) {
+	rawurl := os.Getenv("GIT_LFS_TEST_NEGOTIATE_URL")
+	if len(rawurl) == 0 {
+		t.Skip("set GIT_LFS_TEST_NEGOTIATE_URL to a URL served by a KDC-backed server to run")
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := newNegotiateCredentialHelper().Fill(CredsFromURL(u, false))
+	assert.Nil(t, err)
+	assert.Equal(t, "Negotiate", creds["authtype"])
+	assert.NotEmpty(t, creds["credential"])
+}
@@ -62,8 +62,10 @@ func newNetrcCredentialHelper(osEnv config.Environment) *netrcCredentialHelper {
 	return &netrcCredentialHelper{netrcFinder: netrcFinder, skip: make(map[string]bool)}
 }
 
+func (c *netrcCredentialHelper) Name() string { return "netrc" }
+
 func (c *netrcCredentialHelper) Fill(what Creds) (Creds, error) {
-	host, err := getNetrcHostname(what["host"])
+	host, err := getNetrcHostname(what[CredsHost])
 	if err != nil {
 		return nil, credHelperNoOp
 	}
@@ -74,15 +76,15 @@ func (c *netrcCredentialHelper) Fill(what Creds) (Creds, error) {
 	}
 	if machine := c.netrcFinder.FindMachine(host); machine != nil {
 		creds := make(Creds)
-		creds["username"] = machine.Login
-		creds["password"] = machine.Password
-		creds["protocol"] = what["protocol"]
-		creds["host"] = what["host"]
+		creds[CredsUsername] = machine.Login
+		creds[CredsPassword] = machine.Password
+		creds[CredsProtocol] = what[CredsProtocol]
+		creds[CredsHost] = what[CredsHost]
 		creds["scheme"] = what["scheme"]
-		creds["path"] = what["path"]
+		creds[CredsPath] = what[CredsPath]
 		creds["source"] = "netrc"
 		tracerx.Printf("netrc: git credential fill (%q, %q, %q)",
-			what["protocol"], what["host"], what["path"])
+			what[CredsProtocol], what[CredsHost], what[CredsPath])
 		return creds, nil
 	}
 
@@ -90,6 +92,9 @@ func (c *netrcCredentialHelper) Fill(what Creds) (Creds, error) {
 }
 
 func getNetrcHostname(hostname string) (string, error) {
+	if addr, _, ok := splitIPv6Host(hostname); ok {
+		return addr, nil
+	}
 	if strings.Contains(hostname, ":") {
 		host, _, err := net.SplitHostPort(hostname)
 		if err != nil {
@@ -104,12 +109,12 @@ func getNetrcHostname(hostname string) (string, error) {
 
 func (c *netrcCredentialHelper) Approve(what Creds) error {
 	if what["source"] == "netrc" {
-		host, err := getNetrcHostname(what["host"])
+		host, err := getNetrcHostname(what[CredsHost])
 		if err != nil {
 			return credHelperNoOp
 		}
 		tracerx.Printf("netrc: git credential approve (%q, %q, %q)",
-			what["protocol"], what["host"], what["path"])
+			what[CredsProtocol], what[CredsHost], what[CredsPath])
 		c.mu.Lock()
 		c.skip[host] = false
 		c.mu.Unlock()
@@ -120,13 +125,13 @@ func (c *netrcCredentialHelper) Approve(what Creds) error {
 
 func (c *netrcCredentialHelper) Reject(what Creds) error {
 	if what["source"] == "netrc" {
-		host, err := getNetrcHostname(what["host"])
+		host, err := getNetrcHostname(what[CredsHost])
 		if err != nil {
 			return credHelperNoOp
 		}
 
 		tracerx.Printf("netrc: git credential reject (%q, %q, %q)",
-			what["protocol"], what["host"], what["path"])
+			what[CredsProtocol], what[CredsHost], what[CredsPath])
 		c.mu.Lock()
 		c.skip[host] = true
 		c.mu.Unlock()
@@ -72,11 +72,35 @@ func TestNetrcWithBadHost(t *testing.T) {
 	}
 }
 
+func TestNetrcWithIPv6Host(t *testing.T) {
+	var netrcHelper netrcCredentialHelper
+	netrcHelper.netrcFinder = &fakeNetrc{}
+
+	for _, host := range []string{"[::1]", "[::1]:8080"} {
+		what := make(Creds)
+		what["protocol"] = "http"
+		what["host"] = host
+
+		creds, err := netrcHelper.Fill(what)
+		if err != nil {
+			t.Fatalf("error retrieving netrc credentials for %s: %s", host, err)
+		}
+
+		username := creds["username"]
+		if username != "ipv6" {
+			t.Fatalf("bad username for %s: %s", host, username)
+		}
+	}
+}
+
 type fakeNetrc struct{}
 
 func (n *fakeNetrc) FindMachine(host string) *netrc.Machine {
 	if strings.Contains(host, "netrc") {
 		return &netrc.Machine{Login: "abc", Password: "def"}
 	}
+	if host == "::1" {
+		return &netrc.Machine{Login: "ipv6", Password: "def"}
+	}
 	return nil
 }
@@ -0,0 +1,84 @@
+package creds
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/git-lfs/git-lfs/errors"
+	"github.com/rubyist/tracerx"
+)
+
+// ProcessCredentialHelper is a CredentialHelper which runs a credential helper
+// program directly, rather than through 'git credential', which would consult
+// every configured "credential.helper". The program is run as "<program> get",
+// "<program> store", or "<program> erase", and speaks the credential helper
+// protocol described in gitcredentials(7) on its standard input and output.
+// It is enabled by "lfs.credentialHelperProgram".
+type ProcessCredentialHelper struct {
+	// Program is the path of the credential helper program, such as
+	// "/usr/lib/git-core/git-credential-store".
+	Program string
+
+	// allowedEnv, if non-nil, are the only environment variables, besides
+	// PATH, with which the program is run, as given by
+	// "lfs.credentialhelperenv".
+	allowedEnv []string
+}
+
+func (h *ProcessCredentialHelper) Name() string { return "process" }
+
+// Fill implements CredentialHelper.Fill by running "<program> get". As with
+// Git, the fields the program returns are merged into the requested Creds. If
+// it returns neither a password nor a credential, the next credential helper
+// is consulted.
+func (h *ProcessCredentialHelper) Fill(what Creds) (Creds, error) {
+	output, err := h.exec("get", what)
+	if err != nil {
+		return nil, err
+	}
+
+	filled := parseCreds(output)
+	zeroBytes(output)
+	if len(filled[CredsPassword]) == 0 && len(filled[CredsCredential]) == 0 {
+		return nil, credHelperNoOp
+	}
+
+	creds := copyCreds(what)
+	for k, v := range filled {
+		creds[k] = v
+	}
+	return creds, nil
+}
+
+// Approve implements CredentialHelper.Approve by running "<program> store".
+// As with the vault helper, the credentials are not passed on to 'git
+// credential', so are stored only by the program.
+func (h *ProcessCredentialHelper) Approve(creds Creds) error {
+	_, err := h.exec("store", creds)
+	return err
+}
+
+// Reject implements CredentialHelper.Reject by running "<program> erase".
+func (h *ProcessCredentialHelper) Reject(creds Creds) error {
+	_, err := h.exec("erase", creds)
+	return err
+}
+
+func (h *ProcessCredentialHelper) exec(operation string, input Creds) ([]byte, error) {
+	var output bytes.Buffer
+	cmd := exec.Command(h.Program, operation)
+	cmd.Stdin = bufferCreds(input)
+	cmd.Stdout = &output
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(credentialHelperEnv(h.allowedEnv), credentialRecursionEnv+"=1")
+
+	started := time.Now()
+	err := cmd.Run()
+	tracerx.Printf("creds: '%s %s' took %s", h.Program, operation, time.Since(started))
+	if err != nil {
+		return nil, errors.Wrapf(err, "credential helper program %q %s", h.Program, operation)
+	}
+	return output.Bytes(), nil
+}
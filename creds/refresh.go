@@ -0,0 +1,168 @@
+package creds
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/git-lfs/git-lfs/config"
+	"github.com/git-lfs/git-lfs/errors"
+	"github.com/rubyist/tracerx"
+)
+
+// defaultRefreshWindow is how long before their "password_expiry_utc" cached
+// credentials are refreshed, if "lfs.credentialrefreshwindow" is not set.
+const defaultRefreshWindow = time.Minute
+
+// oauthRefreshCredentialHelper is a CredentialHelper which refreshes cached
+// OAuth access tokens shortly before they expire, with a refresh_token grant
+// (RFC 6749, section 6) to the "credential.<url>.tokenEndpoint", rather than
+// prompting for new credentials once they have. It is consulted before the
+// credential cache, and only for URLs with a token endpoint.
+//
+// Credentials are refreshed if they are cached with an "oauth_refresh_token"
+// and a "password_expiry_utc" within the window given by
+// "lfs.credentialrefreshwindow". The new access token replaces the cached
+// "credential", for credentials with an "authtype", or the "password"
+// otherwise, and is passed on to the other credential helpers once approved.
+// If the token cannot be refreshed, the cached credentials are discarded, so
+// that new ones are filled as usual.
+type oauthRefreshCredentialHelper struct {
+	cache     *credentialCacher
+	window    time.Duration
+	urlConfig *config.URLConfig
+
+	// httpClient returns the HTTP client for requests to the given token
+	// endpoint.
+	httpClient func(rawurl string) (*http.Client, error)
+}
+
+func newOAuthRefreshCredentialHelper(gitEnv config.Environment, urlConfig *config.URLConfig, cache *credentialCacher, httpClient func(string) (*http.Client, error)) *oauthRefreshCredentialHelper {
+	h := &oauthRefreshCredentialHelper{
+		cache:      cache,
+		window:     defaultRefreshWindow,
+		urlConfig:  urlConfig,
+		httpClient: httpClient,
+	}
+	if value, ok := gitEnv.Get("lfs.credentialrefreshwindow"); ok {
+		if window, err := parseSeconds(value); err == nil {
+			h.window = window
+		} else {
+			tracerx.Printf("creds: invalid lfs.credentialrefreshwindow %q: %s", value, err)
+		}
+	}
+	return h
+}
+
+func (h *oauthRefreshCredentialHelper) Name() string { return "oauth refresh" }
+
+// tokenEndpoint returns the "credential.<url>.tokenEndpoint" for the given
+// URL, if any.
+func (h *oauthRefreshCredentialHelper) tokenEndpoint(rawurl string) (string, bool) {
+	endpoint, ok := h.urlConfig.Get("credential", rawurl, "tokenendpoint")
+	return endpoint, ok && len(endpoint) > 0
+}
+
+func (h *oauthRefreshCredentialHelper) Fill(what Creds) (Creds, error) {
+	rawurl := configURL(what)
+	endpoint, ok := h.tokenEndpoint(rawurl)
+	if !ok {
+		return nil, credHelperNoOp
+	}
+
+	defer h.cache.lockFill(credCacheKey(what))()
+
+	cached, ok := h.cache.peek(what)
+	if !ok || len(cached.OAuthRefreshToken()) == 0 {
+		return nil, credHelperNoOp
+	}
+	expiry, ok := cached.PasswordExpiry()
+	if !ok || expiry.Sub(timeNow()) > h.window {
+		return nil, credHelperNoOp
+	}
+
+	clientID, _ := h.urlConfig.Get("credential", rawurl, "clientid")
+	refreshed, err := h.refresh(endpoint, clientID, cached)
+	if err != nil {
+		tracerx.Printf("creds: %s", err)
+		h.cache.Reject(cached)
+		return nil, credHelperNoOp
+	}
+
+	tracerx.Printf("creds: refreshed OAuth access token for %s://%s", what[CredsProtocol], what[CredsHost])
+	h.cache.promote(what, refreshed)
+	return refreshed, nil
+}
+
+// refresh returns the given credentials with their access token replaced by
+// one from a refresh_token grant to the given token endpoint, identifying the
+// client with the given client ID, if any, as public clients must.
+func (h *oauthRefreshCredentialHelper) refresh(endpoint, clientID string, cached Creds) (Creds, error) {
+	form := url.Values{
+		"grant_type":    []string{"refresh_token"},
+		"refresh_token": []string{cached.OAuthRefreshToken()},
+	}
+	if len(clientID) > 0 {
+		form.Set("client_id", clientID)
+	}
+
+	client, err := h.httpClient(endpoint)
+	if err != nil {
+		return nil, errors.Wrapf(err, "oauth: refreshing token at %s", endpoint)
+	}
+	res, err := client.PostForm(endpoint, form)
+	if err != nil {
+		return nil, errors.Wrapf(err, "oauth: refreshing token at %s", endpoint)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("oauth: refreshing token at %s: HTTP %d", endpoint, res.StatusCode)
+	}
+
+	var token struct {
+		AccessToken  string `json:"access_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return nil, errors.Wrapf(err, "oauth: decoding token from %s", endpoint)
+	}
+	if len(token.AccessToken) == 0 {
+		return nil, errors.Errorf("oauth: token from %s has no %q", endpoint, "access_token")
+	}
+
+	refreshed := copyCreds(cached)
+	if len(refreshed[CredsCredential]) > 0 {
+		refreshed[CredsCredential] = token.AccessToken
+	} else {
+		refreshed[CredsPassword] = token.AccessToken
+	}
+	if token.ExpiresIn > 0 {
+		expiry := timeNow().Add(time.Duration(token.ExpiresIn) * time.Second)
+		refreshed[CredsPasswordExpiryUTC] = strconv.FormatInt(expiry.Unix(), 10)
+	} else {
+		delete(refreshed, CredsPasswordExpiryUTC)
+	}
+	// Servers need not issue a new refresh token, in which case the old
+	// one remains valid.
+	if len(token.RefreshToken) > 0 {
+		refreshed[CredsOAuthRefreshToken] = token.RefreshToken
+	}
+	return refreshed, nil
+}
+
+// Approve implements CredentialHelper.Approve. Refreshed credentials are
+// cached, and passed on to the other credential helpers, by the credential
+// cache.
+func (h *oauthRefreshCredentialHelper) Approve(creds Creds) error {
+	return credHelperNoOp
+}
+
+// Reject implements CredentialHelper.Reject. Rejected credentials are removed
+// by the credential cache.
+func (h *oauthRefreshCredentialHelper) Reject(creds Creds) error {
+	return credHelperNoOp
+}
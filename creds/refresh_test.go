@@ -0,0 +1,194 @@
+package creds
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/git-lfs/git-lfs/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentialHelperContextOAuthRefresh(t *testing.T) {
+	defer fakeGit(t, "", 0)()
+
+	now := time.Unix(1000, 0)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	var grants []url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Nil(t, r.ParseForm())
+		grants = append(grants, r.PostForm)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"new-token","token_type":"bearer","expires_in":3600,"refresh_token":"new-refresh"}`))
+	}))
+	defer srv.Close()
+
+	// 'git credential' prompts by answering "foo" and "prompted", logging
+	// each call
+	dir := os.Getenv("PATH")
+	calls := filepath.Join(dir, "calls")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$*\" >> %q\nwhile read line; do :; done\necho username=foo\necho password=prompted\n", calls)
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "git"), []byte(script), 0755))
+
+	ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"credential.https://example.com.tokenendpoint": []string{srv.URL + "/token"},
+		"lfs.credentialrefreshwindow":                  []string{"5m"},
+	})), config.EnvironmentOf(config.MapFetcher(nil)))
+	ctxt.netrcCredHelper = nil
+	ctxt.builtinCredHelper = nil
+	ctxt.commandCredHelper.gitVersion = func() (string, error) { return "git version 2.30.0", nil }
+	u, _ := url.Parse("https://example.com/repo.git")
+
+	cached := Creds{
+		"protocol":             "https",
+		"host":                 "example.com",
+		"username":             "foo",
+		"password":             "old-token",
+		CredsOAuthRefreshToken: "old-refresh",
+		CredsPasswordExpiryUTC: strconv.FormatInt(now.Add(time.Hour).Unix(), 10),
+	}
+	ctxt.cachingCredHelper.Approve(cached)
+
+	// credentials which expire after the window are used as they are
+	wrapper := ctxt.GetCredentialHelper(nil, u)
+	require.Nil(t, wrapper.FillCreds())
+	assert.Equal(t, "old-token", wrapper.Creds[CredsPassword])
+	assert.Empty(t, grants)
+
+	// credentials which expire within it are refreshed, without prompting
+	now = now.Add(56 * time.Minute)
+	wrapper = ctxt.GetCredentialHelper(nil, u)
+	require.Nil(t, wrapper.FillCreds())
+	assert.Equal(t, "foo", wrapper.Creds[CredsUsername])
+	assert.Equal(t, "new-token", wrapper.Creds[CredsPassword])
+	assert.Equal(t, "new-refresh", wrapper.Creds[CredsOAuthRefreshToken])
+	assert.Equal(t, strconv.FormatInt(now.Add(time.Hour).Unix(), 10), wrapper.Creds[CredsPasswordExpiryUTC])
+	require.Len(t, grants, 1)
+	assert.Equal(t, "refresh_token", grants[0].Get("grant_type"))
+	assert.Equal(t, "old-refresh", grants[0].Get("refresh_token"))
+
+	// the refreshed credentials are cached
+	wrapper = ctxt.GetCredentialHelper(nil, u)
+	require.Nil(t, wrapper.FillCreds())
+	assert.Equal(t, "new-token", wrapper.Creds[CredsPassword])
+	assert.Len(t, grants, 1)
+
+	_, err := os.Stat(calls)
+	assert.True(t, os.IsNotExist(err), "git credential was run")
+}
+
+func TestCredentialHelperContextOAuthRefreshFailure(t *testing.T) {
+	defer fakeGit(t, "", 0)()
+
+	now := time.Unix(1000, 0)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	var grants int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		grants++
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+	}))
+	defer srv.Close()
+
+	dir := os.Getenv("PATH")
+	calls := filepath.Join(dir, "calls")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$*\" >> %q\nwhile read line; do :; done\necho username=foo\necho password=prompted\n", calls)
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "git"), []byte(script), 0755))
+
+	ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"credential.https://example.com.tokenendpoint": []string{srv.URL + "/token"},
+		"lfs.credentialrefreshwindow":                  []string{"5m"},
+	})), config.EnvironmentOf(config.MapFetcher(nil)))
+	ctxt.netrcCredHelper = nil
+	ctxt.builtinCredHelper = nil
+	ctxt.commandCredHelper.gitVersion = func() (string, error) { return "git version 2.30.0", nil }
+	u, _ := url.Parse("https://example.com/repo.git")
+
+	ctxt.cachingCredHelper.Approve(Creds{
+		"protocol":             "https",
+		"host":                 "example.com",
+		"username":             "foo",
+		"password":             "old-token",
+		CredsOAuthRefreshToken: "old-refresh",
+		CredsPasswordExpiryUTC: strconv.FormatInt(now.Add(time.Minute).Unix(), 10),
+	})
+
+	// the stale credentials are discarded, and new ones prompted for
+	wrapper := ctxt.GetCredentialHelper(nil, u)
+	require.Nil(t, wrapper.FillCreds())
+	assert.Equal(t, 1, grants)
+	assert.Equal(t, "prompted", wrapper.Creds[CredsPassword])
+	assert.Empty(t, wrapper.Creds[CredsOAuthRefreshToken])
+
+	by, err := ioutil.ReadFile(calls)
+	require.Nil(t, err)
+	assert.Contains(t, string(by), "credential fill")
+}
+
+// recordingTransport is an http.RoundTripper which records the URL of each
+// request before passing it to http.DefaultTransport.
+type recordingTransport struct {
+	urls []string
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.urls = append(t.urls, req.URL.String())
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestCredentialHelperContextOAuthRefreshClientID(t *testing.T) {
+	now := time.Unix(1000, 0)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	var grants []url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Nil(t, r.ParseForm())
+		grants = append(grants, r.PostForm)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"new-token","expires_in":3600}`))
+	}))
+	defer srv.Close()
+
+	ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"credential.https://example.com.tokenendpoint": []string{srv.URL + "/token"},
+		"credential.https://example.com.clientid":      []string{"git-lfs"},
+	})), config.EnvironmentOf(config.MapFetcher(nil)))
+	tr := &recordingTransport{}
+	var transportURLs []string
+	ctxt.SetTransport(func(u *url.URL) (http.RoundTripper, error) {
+		transportURLs = append(transportURLs, u.String())
+		return tr, nil
+	})
+
+	ctxt.cachingCredHelper.Approve(Creds{
+		"protocol":             "https",
+		"host":                 "example.com",
+		"username":             "foo",
+		"password":             "old-token",
+		CredsOAuthRefreshToken: "old-refresh",
+		CredsPasswordExpiryUTC: strconv.FormatInt(now.Add(time.Second).Unix(), 10),
+	})
+
+	// the client ID is sent with the grant, which is made with the
+	// configured transport
+	creds, err := ctxt.refreshCredHelper.Fill(Creds{"protocol": "https", "host": "example.com"})
+	require.Nil(t, err)
+	assert.Equal(t, "new-token", creds[CredsPassword])
+	require.Len(t, grants, 1)
+	assert.Equal(t, "git-lfs", grants[0].Get("client_id"))
+	assert.Equal(t, []string{srv.URL + "/token"}, transportURLs)
+	assert.Equal(t, []string{srv.URL + "/token"}, tr.urls)
+}
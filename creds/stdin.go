@@ -0,0 +1,139 @@
+package creds
+
+import (
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/git-lfs/git-lfs/errors"
+	isatty "github.com/mattn/go-isatty"
+	"github.com/rubyist/tracerx"
+)
+
+// stdinCredentialHelper is a CredentialHelper which reads a single credential
+// from standard input the first time it is filled, and returns it for every
+// host thereafter. This allows scripts to pipe a token into a Git LFS command
+// once, without configuring a credential helper.
+//
+// The line read is either "username:password", or a token, which is used as
+// the password, along with any username given in the URL.
+type stdinCredentialHelper struct {
+	in         io.Reader
+	isTerminal func() bool
+
+	once  sync.Once
+	creds Creds
+	err   error
+}
+
+// defaultStdinCredentialHelper reads from the standard input of the process.
+// It is shared by every CredentialHelperContext, since standard input can only
+// be consumed once. Commands read it with ReadStdinCredential when they start,
+// before anything else reads from standard input.
+var defaultStdinCredentialHelper = newStdinCredentialHelper(os.Stdin)
+
+func newStdinCredentialHelper(in *os.File) *stdinCredentialHelper {
+	return &stdinCredentialHelper{
+		in: in,
+		isTerminal: func() bool {
+			return isatty.IsTerminal(in.Fd()) || isatty.IsCygwinTerminal(in.Fd())
+		},
+	}
+}
+
+func (h *stdinCredentialHelper) Name() string { return "stdin" }
+
+// ReadStdinCredential reads the credential used when "lfs.credentialFromStdin"
+// is enabled from the standard input of the process, if it has not been read
+// yet, rather than waiting until credentials are first filled, when something
+// else may have read from standard input. Any error reading it is returned,
+// and again each time credentials are filled.
+func ReadStdinCredential() error {
+	return defaultStdinCredentialHelper.load()
+}
+
+func (h *stdinCredentialHelper) Fill(what Creds) (Creds, error) {
+	if err := h.load(); err != nil {
+		return nil, err
+	}
+
+	creds := make(Creds)
+	for k, v := range h.creds {
+		creds[k] = v
+	}
+	if _, ok := creds[CredsUsername]; !ok {
+		creds[CredsUsername] = what[CredsUsername]
+	}
+	creds[CredsProtocol] = what[CredsProtocol]
+	creds[CredsHost] = what[CredsHost]
+	if path, ok := what[CredsPath]; ok {
+		creds[CredsPath] = path
+	}
+	return creds, nil
+}
+
+// load reads the credential from h.in the first time it is called, returning
+// any error reading it.
+func (h *stdinCredentialHelper) load() error {
+	h.once.Do(h.read)
+	return h.err
+}
+
+// read reads the credential from h.in. It must only be called once.
+func (h *stdinCredentialHelper) read() {
+	if h.isTerminal != nil && h.isTerminal() {
+		h.err = errors.New("lfs.credentialFromStdin is set, but standard input is a terminal; pipe a credential into Git LFS instead")
+		return
+	}
+
+	tracerx.Printf("creds: reading credential from standard input")
+
+	line, err := readLineUnbuffered(h.in)
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		if err == nil || err == io.EOF {
+			h.err = errors.New("lfs.credentialFromStdin is set, but no credential was read from standard input")
+		} else {
+			h.err = errors.Wrap(err, "reading credential from standard input")
+		}
+		return
+	}
+
+	h.creds = make(Creds)
+	if pieces := strings.SplitN(line, ":", 2); len(pieces) == 2 {
+		h.creds[CredsUsername] = pieces[0]
+		h.creds[CredsPassword] = pieces[1]
+	} else {
+		h.creds[CredsPassword] = line
+	}
+}
+
+// readLineUnbuffered reads from r up to and including the first '\n', one
+// byte at a time, so that nothing after the line is consumed, and remains to
+// be read by whatever else reads from r, such as the ref lines given to
+// pre-push or a stream of pointers.
+func readLineUnbuffered(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			line = append(line, b[0])
+			if b[0] == '\n' {
+				return string(line), nil
+			}
+		}
+		if err != nil {
+			return string(line), err
+		}
+	}
+}
+
+// Approve implements CredentialHelper.Approve, and returns nil, since a
+// credential read from standard input is never stored.
+func (h *stdinCredentialHelper) Approve(_ Creds) error { return nil }
+
+// Reject implements CredentialHelper.Reject, and returns nil, since a
+// credential read from standard input cannot be read again.
+func (h *stdinCredentialHelper) Reject(_ Creds) error { return nil }
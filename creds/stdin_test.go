@@ -0,0 +1,112 @@
+package creds
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStdinCredentialHelperUsernamePassword(t *testing.T) {
+	h := &stdinCredentialHelper{in: strings.NewReader("foo:bar\nrest\n")}
+
+	creds, err := h.Fill(Creds{"protocol": "https", "host": "a.example.com"})
+	assert.Nil(t, err)
+	assert.Equal(t, Creds{
+		"protocol": "https",
+		"host":     "a.example.com",
+		"username": "foo",
+		"password": "bar",
+	}, creds)
+
+	// the credential is read once, and used for every host
+	creds, err = h.Fill(Creds{"protocol": "https", "host": "b.example.com"})
+	assert.Nil(t, err)
+	assert.Equal(t, Creds{
+		"protocol": "https",
+		"host":     "b.example.com",
+		"username": "foo",
+		"password": "bar",
+	}, creds)
+}
+
+func TestStdinCredentialHelperLeavesRest(t *testing.T) {
+	in := strings.NewReader("foo:bar\nrefs/heads/main 1234 refs/heads/main 5678\n")
+	h := &stdinCredentialHelper{in: in}
+
+	_, err := h.Fill(Creds{"protocol": "https", "host": "example.com"})
+	assert.Nil(t, err)
+
+	// only the credential's line is consumed
+	rest, err := ioutil.ReadAll(h.in)
+	assert.Nil(t, err)
+	assert.Equal(t, "refs/heads/main 1234 refs/heads/main 5678\n", string(rest))
+}
+
+func TestStdinCredentialHelperLoad(t *testing.T) {
+	in := strings.NewReader("foo:bar\nrest\n")
+	h := &stdinCredentialHelper{in: in}
+
+	// the credential is read when loaded, before it is first filled
+	assert.Nil(t, h.load())
+	rest, err := ioutil.ReadAll(in)
+	assert.Nil(t, err)
+	assert.Equal(t, "rest\n", string(rest))
+
+	creds, err := h.Fill(Creds{"protocol": "https", "host": "example.com"})
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", creds[CredsUsername])
+	assert.Equal(t, "bar", creds[CredsPassword])
+}
+
+func TestStdinCredentialHelperToken(t *testing.T) {
+	h := &stdinCredentialHelper{in: strings.NewReader("s3cr3t\r\n")}
+
+	creds, err := h.Fill(Creds{"protocol": "https", "host": "example.com", "username": "ci"})
+	assert.Nil(t, err)
+	assert.Equal(t, Creds{
+		"protocol": "https",
+		"host":     "example.com",
+		"username": "ci",
+		"password": "s3cr3t",
+	}, creds)
+}
+
+func TestStdinCredentialHelperConsumed(t *testing.T) {
+	h := &stdinCredentialHelper{in: strings.NewReader("")}
+
+	_, err := h.Fill(Creds{"protocol": "https", "host": "example.com"})
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "no credential was read from standard input")
+	}
+}
+
+func TestStdinCredentialHelperTerminal(t *testing.T) {
+	h := &stdinCredentialHelper{
+		in:         strings.NewReader("foo:bar\n"),
+		isTerminal: func() bool { return true },
+	}
+
+	_, err := h.Fill(Creds{"protocol": "https", "host": "example.com"})
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "standard input is a terminal")
+	}
+}
+
+func TestStdinCredentialHelperPipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	w.WriteString("foo:bar\n")
+	w.Close()
+
+	creds, err := newStdinCredentialHelper(r).Fill(Creds{"protocol": "https", "host": "example.com"})
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", creds["username"])
+	assert.Equal(t, "bar", creds["password"])
+}
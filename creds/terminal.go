@@ -0,0 +1,168 @@
+package creds
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/git-lfs/git-lfs/errors"
+	"github.com/rubyist/tracerx"
+)
+
+// TerminalCredentialHelper is a CredentialHelper which prompts for credentials
+// on the controlling terminal itself, reading the username as it is typed, and
+// the password with echo disabled. It is consulted last, when no credential
+// helper, askpass program, or 'git credential' filled any credentials, if
+// "lfs.terminalprompt" is enabled, and never prompts if GIT_TERMINAL_PROMPT
+// disables prompting, "credential.interactive" is "never", or there is no
+// terminal.
+type TerminalCredentialHelper struct {
+	// SkipPrompt is true if GIT_TERMINAL_PROMPT disables prompting.
+	SkipPrompt bool
+
+	// openTerminal opens the controlling terminal, returning the files
+	// from which to read and to which to write, which may be the same. If
+	// nil, openControllingTerminal is used.
+	openTerminal func() (in, out *os.File, err error)
+}
+
+func (h *TerminalCredentialHelper) Name() string { return "terminal" }
+
+// Fill implements CredentialHelper.Fill by prompting for the username, unless
+// one was requested, and the password, as Git does. If there is no terminal,
+// or no password is entered, the next credential helper is consulted.
+func (h *TerminalCredentialHelper) Fill(what Creds) (Creds, error) {
+	if h.SkipPrompt {
+		tracerx.Printf("creds: GIT_TERMINAL_PROMPT disables prompting on the terminal")
+		return nil, credHelperNoOp
+	}
+
+	open := h.openTerminal
+	if open == nil {
+		open = openControllingTerminal
+	}
+	in, out, err := open()
+	if err != nil {
+		tracerx.Printf("creds: no terminal to prompt on: %s", err)
+		return nil, credHelperNoOp
+	}
+	defer in.Close()
+	if out != in {
+		defer out.Close()
+	}
+
+	r := bufio.NewReader(in)
+	target := fmt.Sprintf("%s://%s", what[CredsProtocol], what[CredsHost])
+
+	username := what[CredsUsername]
+	if len(username) == 0 {
+		fmt.Fprintf(out, "Username for '%s': ", target)
+		if username, err = readTerminalLine(r); err != nil {
+			return nil, errors.Wrap(err, "terminal: reading username")
+		}
+	}
+
+	restore, err := disableEcho(in)
+	if err != nil {
+		return nil, errors.Wrap(err, "terminal: disabling echo")
+	}
+	restore = trackEchoRestore(restore)
+	defer restore()
+
+	fmt.Fprintf(out, "Password for '%s://%s@%s': ", what[CredsProtocol], username, what[CredsHost])
+	password, err := readTerminalLine(r)
+	restore()
+	// The newline typed after the password was not echoed.
+	fmt.Fprintln(out)
+	if err != nil {
+		return nil, errors.Wrap(err, "terminal: reading password")
+	}
+	if len(password) == 0 {
+		return nil, credHelperNoOp
+	}
+
+	creds := make(Creds)
+	creds[CredsProtocol] = what[CredsProtocol]
+	creds[CredsHost] = what[CredsHost]
+	if p, ok := what[CredsPath]; ok {
+		creds[CredsPath] = p
+	}
+	creds[CredsUsername] = username
+	creds[CredsPassword] = password
+	return creds, nil
+}
+
+// Approve implements CredentialHelper.Approve. Credentials entered on the
+// terminal are cached, and passed on to the other credential helpers, as
+// those entered at any other prompt are.
+func (h *TerminalCredentialHelper) Approve(creds Creds) error {
+	return credHelperNoOp
+}
+
+// Reject implements CredentialHelper.Reject.
+func (h *TerminalCredentialHelper) Reject(creds Creds) error {
+	return credHelperNoOp
+}
+
+// readTerminalLine reads one line typed on the terminal, without its line
+// ending.
+func readTerminalLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+var (
+	// echoRestores holds the functions which turn echo back on for each
+	// terminal on which a password is being read, for RestoreTerminals.
+	echoRestores   = make(map[int]func())
+	echoRestoresID int
+	echoRestoresMu sync.Mutex
+)
+
+// trackEchoRestore records the given function, which turns echo back on for a
+// terminal, so that RestoreTerminals calls it if Git LFS exits before the
+// password has been read. It returns a function which calls it, only once,
+// and forgets it.
+func trackEchoRestore(restore func()) func() {
+	var once sync.Once
+	tracked := func() { once.Do(restore) }
+
+	echoRestoresMu.Lock()
+	id := echoRestoresID
+	echoRestoresID++
+	echoRestores[id] = tracked
+	echoRestoresMu.Unlock()
+
+	return func() {
+		echoRestoresMu.Lock()
+		delete(echoRestores, id)
+		echoRestoresMu.Unlock()
+		tracked()
+	}
+}
+
+// RestoreTerminals turns echo back on for any terminal on which a password is
+// being read, as Git does when it is interrupted at a password prompt. It is
+// called when Git LFS exits because of a signal, so that a user who presses
+// Ctrl-C at the prompt is not left with a terminal which does not echo.
+func RestoreTerminals() {
+	echoRestoresMu.Lock()
+	restores := make([]func(), 0, len(echoRestores))
+	for _, restore := range echoRestores {
+		restores = append(restores, restore)
+	}
+	echoRestoresMu.Unlock()
+
+	for _, restore := range restores {
+		restore()
+	}
+}
@@ -0,0 +1,172 @@
+package creds
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// testPTY is a pseudo-terminal, whose master side plays the user, typing
+// input and collecting what is shown on the terminal.
+type testPTY struct {
+	master *os.File
+	slave  string
+
+	mu     sync.Mutex
+	output bytes.Buffer
+}
+
+func newTestPTY(t *testing.T) *testPTY {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminal: %s", err)
+	}
+	fd := int(master.Fd())
+	require.Nil(t, unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0))
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	require.Nil(t, err)
+
+	p := &testPTY{master: master, slave: fmt.Sprintf("/dev/pts/%d", n)}
+	if _, err := os.Stat(p.slave); err != nil {
+		master.Close()
+		t.Skipf("no pseudo-terminal: %s", err)
+	}
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := master.Read(buf)
+			p.mu.Lock()
+			p.output.Write(buf[:n])
+			p.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// open opens the terminal, as the TerminalCredentialHelper does.
+func (p *testPTY) open() (*os.File, *os.File, error) {
+	f, err := os.OpenFile(p.slave, os.O_RDWR|unix.O_NOCTTY, 0)
+	return f, f, err
+}
+
+func (p *testPTY) Output() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.output.String()
+}
+
+// waitFor waits until the given text is shown on the terminal.
+func (p *testPTY) waitFor(t *testing.T, text string) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if strings.Contains(p.Output(), text) {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %q, got %q", text, p.Output())
+}
+
+func TestTerminalCredentialHelperFill(t *testing.T) {
+	p := newTestPTY(t)
+	defer p.master.Close()
+
+	helper := &TerminalCredentialHelper{openTerminal: p.open}
+	type result struct {
+		creds Creds
+		err   error
+	}
+	results := make(chan result, 1)
+	go func() {
+		creds, err := helper.Fill(Creds{"protocol": "https", "host": "example.com", "path": "repo.git"})
+		results <- result{creds, err}
+	}()
+
+	p.waitFor(t, "Username for 'https://example.com': ")
+	p.master.Write([]byte("foo\n"))
+	p.waitFor(t, "Password for 'https://foo@example.com': ")
+	p.master.Write([]byte("s3cr3t\n"))
+
+	r := <-results
+	require.Nil(t, r.err)
+	assert.Equal(t, Creds{
+		"protocol": "https",
+		"host":     "example.com",
+		"path":     "repo.git",
+		"username": "foo",
+		"password": "s3cr3t",
+	}, r.creds)
+
+	// the username is echoed, but not the password
+	assert.Contains(t, p.Output(), "': foo")
+	assert.NotContains(t, p.Output(), "s3cr3t")
+
+	// and echo is turned back on
+	tty, _, err := p.open()
+	require.Nil(t, err)
+	defer tty.Close()
+	termios, err := unix.IoctlGetTermios(int(tty.Fd()), ioctlReadTermios)
+	require.Nil(t, err)
+	assert.NotEqual(t, uint32(0), uint32(termios.Lflag&unix.ECHO))
+}
+
+func TestTerminalCredentialHelperFillKnownUsername(t *testing.T) {
+	p := newTestPTY(t)
+	defer p.master.Close()
+
+	helper := &TerminalCredentialHelper{openTerminal: p.open}
+	results := make(chan Creds, 1)
+	go func() {
+		creds, _ := helper.Fill(Creds{"protocol": "https", "host": "example.com", "username": "foo"})
+		results <- creds
+	}()
+
+	// only the password is prompted for
+	p.waitFor(t, "Password for 'https://foo@example.com': ")
+	p.master.Write([]byte("s3cr3t\n"))
+
+	creds := <-results
+	assert.Equal(t, "foo", creds[CredsUsername])
+	assert.Equal(t, "s3cr3t", creds[CredsPassword])
+	assert.NotContains(t, p.Output(), "Username for")
+}
+
+func TestTerminalCredentialHelperRestoreTerminals(t *testing.T) {
+	p := newTestPTY(t)
+	defer p.master.Close()
+
+	helper := &TerminalCredentialHelper{openTerminal: p.open}
+	done := make(chan struct{})
+	go func() {
+		helper.Fill(Creds{"protocol": "https", "host": "example.com", "username": "foo"})
+		close(done)
+	}()
+	p.waitFor(t, "Password for 'https://foo@example.com': ")
+
+	tty, _, err := p.open()
+	require.Nil(t, err)
+	defer tty.Close()
+	termios, err := unix.IoctlGetTermios(int(tty.Fd()), ioctlReadTermios)
+	require.Nil(t, err)
+	assert.Equal(t, uint32(0), uint32(termios.Lflag&unix.ECHO))
+
+	// echo is turned back on while the password is still being read, as
+	// when Git LFS exits because the user pressed Ctrl-C
+	RestoreTerminals()
+	termios, err = unix.IoctlGetTermios(int(tty.Fd()), ioctlReadTermios)
+	require.Nil(t, err)
+	assert.NotEqual(t, uint32(0), uint32(termios.Lflag&unix.ECHO))
+
+	p.master.Write([]byte("s3cr3t\n"))
+	<-done
+}
@@ -0,0 +1,36 @@
+// +build !windows
+
+package creds
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// openControllingTerminal opens the controlling terminal of the process, even
+// if standard input is redirected, as in a hook.
+func openControllingTerminal() (*os.File, *os.File, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tty, tty, nil
+}
+
+// disableEcho turns off echo on the given terminal, returning a function which
+// turns it back on.
+func disableEcho(tty *os.File) (func(), error) {
+	fd := int(tty.Fd())
+	termios, err := unix.IoctlGetTermios(fd, ioctlReadTermios)
+	if err != nil {
+		return nil, err
+	}
+
+	noEcho := *termios
+	noEcho.Lflag &^= unix.ECHO
+	if err := unix.IoctlSetTermios(fd, ioctlWriteTermios, &noEcho); err != nil {
+		return nil, err
+	}
+	return func() { unix.IoctlSetTermios(fd, ioctlWriteTermios, termios) }, nil
+}
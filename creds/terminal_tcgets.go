@@ -0,0 +1,10 @@
+// +build linux aix solaris
+
+package creds
+
+import "golang.org/x/sys/unix"
+
+const (
+	ioctlReadTermios  = unix.TCGETS
+	ioctlWriteTermios = unix.TCSETS
+)
@@ -0,0 +1,76 @@
+package creds
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/git-lfs/git-lfs/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTerminalCredentialHelperNoPrompt(t *testing.T) {
+	opened := false
+	open := func() (*os.File, *os.File, error) {
+		opened = true
+		return nil, nil, errors.New("no terminal")
+	}
+
+	// without a terminal, the next credential helper is consulted
+	helper := &TerminalCredentialHelper{openTerminal: open}
+	creds, err := helper.Fill(Creds{"protocol": "https", "host": "example.com"})
+	assert.Equal(t, credHelperNoOp, err)
+	assert.Nil(t, creds)
+	assert.True(t, opened)
+
+	// as it is if GIT_TERMINAL_PROMPT disables prompting, without opening
+	// the terminal at all
+	opened = false
+	helper = &TerminalCredentialHelper{SkipPrompt: true, openTerminal: open}
+	creds, err = helper.Fill(Creds{"protocol": "https", "host": "example.com"})
+	assert.Equal(t, credHelperNoOp, err)
+	assert.Nil(t, creds)
+	assert.False(t, opened)
+}
+
+func TestCredentialHelperContextTerminalPrompt(t *testing.T) {
+	u, _ := url.Parse("https://example.com/repo.git")
+	names := func(ctxt *CredentialHelperContext) []string {
+		var names []string
+		for _, h := range ctxt.describe(u).Helpers {
+			names = append(names, h.Name)
+		}
+		return names
+	}
+	newContext := func(gitConf, osEnv map[string][]string) *CredentialHelperContext {
+		ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(gitConf)), config.EnvironmentOf(config.MapFetcher(osEnv)))
+		ctxt.netrcCredHelper = nil
+		ctxt.builtinCredHelper = nil
+		return ctxt
+	}
+
+	ctxt := newContext(nil, nil)
+	assert.Nil(t, ctxt.terminalCredHelper)
+	assert.Equal(t, []string{"cache", "git credential"}, names(ctxt))
+
+	// the terminal is prompted on last
+	ctxt = newContext(map[string][]string{"lfs.terminalprompt": []string{"true"}}, nil)
+	require.NotNil(t, ctxt.terminalCredHelper)
+	assert.False(t, ctxt.terminalCredHelper.SkipPrompt)
+	assert.Equal(t, []string{"cache", "git credential", "terminal"}, names(ctxt))
+
+	ctxt = newContext(map[string][]string{"lfs.terminalprompt": []string{"true"}}, map[string][]string{
+		"GIT_TERMINAL_PROMPT": []string{"0"},
+	})
+	require.NotNil(t, ctxt.terminalCredHelper)
+	assert.True(t, ctxt.terminalCredHelper.SkipPrompt)
+
+	// but never with credential.interactive=never
+	ctxt = newContext(map[string][]string{
+		"lfs.terminalprompt":     []string{"true"},
+		"credential.interactive": []string{"never"},
+	}, nil)
+	assert.Equal(t, []string{"cache", "git credential"}, names(ctxt))
+}
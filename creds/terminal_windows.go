@@ -0,0 +1,40 @@
+// +build windows
+
+package creds
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// openControllingTerminal opens the console to which the process is attached,
+// even if standard input is redirected, as in a hook.
+func openControllingTerminal() (*os.File, *os.File, error) {
+	in, err := os.OpenFile("CONIN$", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := os.OpenFile("CONOUT$", os.O_WRONLY, 0)
+	if err != nil {
+		in.Close()
+		return nil, nil, err
+	}
+	return in, out, nil
+}
+
+// disableEcho turns off echo on the given console input, returning a function
+// which turns it back on.
+func disableEcho(console *os.File) (func(), error) {
+	handle := windows.Handle(console.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(handle, &mode); err != nil {
+		return nil, err
+	}
+
+	noEcho := (mode &^ windows.ENABLE_ECHO_INPUT) | windows.ENABLE_LINE_INPUT
+	if err := windows.SetConsoleMode(handle, noEcho); err != nil {
+		return nil, err
+	}
+	return func() { windows.SetConsoleMode(handle, mode) }, nil
+}
@@ -0,0 +1,245 @@
+package creds
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/git-lfs/git-lfs/config"
+	"github.com/git-lfs/git-lfs/errors"
+	"github.com/rubyist/tracerx"
+)
+
+// vaultCredentialHelper is a CredentialHelper which reads credentials from a
+// HashiCorp Vault secret. It is enabled by the VAULT_ADDR environment variable,
+// and consulted for every URL with a "credential.<url>.vaultPath", which names
+// the secret to read, such as "secret/data/git-lfs/example".
+//
+// The secret's "username" field, if any, is used as the username, and its
+// "password" or "token" field as the password. Both version 1 and version 2 of
+// the key/value secrets engine are supported. If "credential.<url>.vaultWrite"
+// is enabled, approved credentials are written back to the secret, and
+// rejected ones deleted from it.
+type vaultCredentialHelper struct {
+	addr  string
+	token string
+
+	// newClient returns the HTTP client for requests to the given URL. It
+	// is called for the Vault server once, on first use, and the client
+	// kept in client, which is guarded by clientMu.
+	newClient func(rawurl string) (*http.Client, error)
+	client    *http.Client
+	clientMu  sync.Mutex
+
+	urlConfig *config.URLConfig
+}
+
+func newVaultCredentialHelper(osEnv config.Environment, urlConfig *config.URLConfig, newClient func(string) (*http.Client, error)) *vaultCredentialHelper {
+	addr, _ := osEnv.Get("VAULT_ADDR")
+	if len(addr) == 0 {
+		return nil
+	}
+	token, _ := osEnv.Get("VAULT_TOKEN")
+
+	return &vaultCredentialHelper{
+		addr:      strings.TrimRight(addr, "/"),
+		token:     token,
+		newClient: newClient,
+		urlConfig: urlConfig,
+	}
+}
+
+func (h *vaultCredentialHelper) Name() string { return "vault" }
+
+func (h *vaultCredentialHelper) Fill(what Creds) (Creds, error) {
+	path, ok := h.secretPath(what)
+	if !ok {
+		return nil, credHelperNoOp
+	}
+
+	res, err := h.do("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("vault: reading secret %q: HTTP %d", path, res.StatusCode)
+	}
+
+	var secret struct {
+		LeaseDuration int                    `json:"lease_duration"`
+		Data          map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&secret); err != nil {
+		return nil, errors.Wrapf(err, "vault: decoding secret %q", path)
+	}
+
+	data := secret.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		// Version 2 of the key/value secrets engine nests the secret's
+		// fields alongside its metadata.
+		data = nested
+	}
+
+	password := vaultString(data, "password")
+	if len(password) == 0 {
+		password = vaultString(data, "token")
+	}
+	if len(password) == 0 {
+		return nil, errors.Errorf("vault: secret %q has no %q or %q field", path, "password", "token")
+	}
+
+	tracerx.Printf("creds: filled credentials from vault secret %q", path)
+
+	creds := make(Creds)
+	creds[CredsProtocol] = what[CredsProtocol]
+	creds[CredsHost] = what[CredsHost]
+	if p, ok := what[CredsPath]; ok {
+		creds[CredsPath] = p
+	}
+	if username := vaultString(data, "username"); len(username) > 0 {
+		creds[CredsUsername] = username
+	} else {
+		creds[CredsUsername] = what[CredsUsername]
+	}
+	creds[CredsPassword] = password
+	if secret.LeaseDuration > 0 {
+		expiry := timeNow().Add(time.Duration(secret.LeaseDuration) * time.Second)
+		creds[CredsPasswordExpiryUTC] = strconv.FormatInt(expiry.Unix(), 10)
+	}
+	return creds, nil
+}
+
+// Approve implements CredentialHelper.Approve, and writes the given Creds to
+// the configured secret if "credential.<url>.vaultWrite" is enabled.
+func (h *vaultCredentialHelper) Approve(creds Creds) error {
+	path, ok := h.secretPath(creds)
+	if !ok {
+		return credHelperNoOp
+	}
+	if !h.writable(creds) {
+		return nil
+	}
+
+	fields := map[string]string{CredsPassword: creds[CredsPassword]}
+	if username := creds[CredsUsername]; len(username) > 0 {
+		fields[CredsUsername] = username
+	}
+	var body interface{} = fields
+	if isVaultKVv2(path) {
+		body = map[string]interface{}{"data": fields}
+	}
+
+	by, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	res, err := h.do("POST", path, by)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return errors.Errorf("vault: writing secret %q: HTTP %d", path, res.StatusCode)
+	}
+	return nil
+}
+
+// Reject implements CredentialHelper.Reject, and deletes the configured secret
+// if "credential.<url>.vaultWrite" is enabled. Deleting the data of a secret
+// in a KV version 2 engine only marks its latest version as deleted, leaving
+// it and older versions to be undeleted or read, so for such a secret its
+// metadata is deleted instead, which permanently removes every version.
+func (h *vaultCredentialHelper) Reject(creds Creds) error {
+	path, ok := h.secretPath(creds)
+	if !ok {
+		return credHelperNoOp
+	}
+	if !h.writable(creds) {
+		return nil
+	}
+
+	if isVaultKVv2(path) {
+		path = strings.Replace(path, "/data/", "/metadata/", 1)
+	}
+	res, err := h.do("DELETE", path, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode/100 != 2 && res.StatusCode != http.StatusNotFound {
+		return errors.Errorf("vault: deleting secret %q: HTTP %d", path, res.StatusCode)
+	}
+	return nil
+}
+
+// secretPath returns the "credential.<url>.vaultPath" configured for the URL
+// of the given Creds, if any.
+func (h *vaultCredentialHelper) secretPath(creds Creds) (string, bool) {
+	path, ok := h.urlConfig.Get("credential", configURL(creds), "vaultpath")
+	path = strings.Trim(path, "/")
+	return path, ok && len(path) > 0
+}
+
+// isVaultKVv2 returns whether the given secret path is that of the data of a
+// secret in a KV version 2 engine, such as "secret/data/git-lfs".
+func isVaultKVv2(path string) bool {
+	return strings.Contains(path, "/data/")
+}
+
+func (h *vaultCredentialHelper) writable(creds Creds) bool {
+	return h.urlConfig.Bool("credential", configURL(creds), "vaultwrite", false)
+}
+
+func (h *vaultCredentialHelper) do(method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/v1/%s", h.addr, path), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if len(h.token) > 0 {
+		req.Header.Set("X-Vault-Token", h.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client, err := h.httpClient()
+	if err != nil {
+		return nil, errors.Wrapf(err, "vault: %s secret %q", strings.ToLower(method), path)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "vault: %s secret %q", strings.ToLower(method), path)
+	}
+	return res, nil
+}
+
+// httpClient returns the HTTP client for requests to the Vault server,
+// creating it on first use.
+func (h *vaultCredentialHelper) httpClient() (*http.Client, error) {
+	h.clientMu.Lock()
+	defer h.clientMu.Unlock()
+
+	if h.client == nil {
+		client, err := h.newClient(h.addr)
+		if err != nil {
+			return nil, err
+		}
+		h.client = client
+	}
+	return h.client, nil
+}
+
+func vaultString(data map[string]interface{}, key string) string {
+	s, _ := data[key].(string)
+	return s
+}
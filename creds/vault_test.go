@@ -0,0 +1,250 @@
+package creds
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/git-lfs/git-lfs/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type vaultRequest struct {
+	Method string
+	Path   string
+	Token  string
+	Body   map[string]interface{}
+}
+
+func newMockVault(t *testing.T, secrets map[string]string) (*httptest.Server, *[]vaultRequest) {
+	var requests []vaultRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := vaultRequest{Method: r.Method, Path: r.URL.Path, Token: r.Header.Get("X-Vault-Token")}
+		if r.Method == "POST" {
+			require.Nil(t, json.NewDecoder(r.Body).Decode(&req.Body))
+		}
+		requests = append(requests, req)
+
+		switch r.Method {
+		case "GET":
+			secret, ok := secrets[r.URL.Path]
+			if !ok {
+				w.WriteHeader(404)
+				return
+			}
+			w.Write([]byte(secret))
+		default:
+			w.WriteHeader(204)
+		}
+	}))
+	return srv, &requests
+}
+
+func TestVaultCredentialHelperKVv1(t *testing.T) {
+	srv, requests := newMockVault(t, map[string]string{
+		"/v1/secret/git-lfs": `{"lease_duration":0,"data":{"username":"vault-user","password":"vault-pass"}}`,
+	})
+	defer srv.Close()
+
+	ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"credential.https://git.example.com.vaultpath": []string{"secret/git-lfs"},
+	})), config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"VAULT_ADDR":  []string{srv.URL},
+		"VAULT_TOKEN": []string{"root-token"},
+	})))
+	require.NotNil(t, ctxt.vaultCredHelper)
+
+	creds, err := ctxt.vaultCredHelper.Fill(Creds{"protocol": "https", "host": "git.example.com"})
+	assert.Nil(t, err)
+	assert.Equal(t, Creds{
+		"protocol": "https",
+		"host":     "git.example.com",
+		"username": "vault-user",
+		"password": "vault-pass",
+	}, creds)
+
+	require.Len(t, *requests, 1)
+	assert.Equal(t, "GET", (*requests)[0].Method)
+	assert.Equal(t, "root-token", (*requests)[0].Token)
+}
+
+func TestVaultCredentialHelperKVv2WithLease(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	srv, _ := newMockVault(t, map[string]string{
+		"/v1/secret/data/git-lfs": `{"lease_duration":3600,"data":{"data":{"token":"t0k3n"},"metadata":{"version":3}}}`,
+	})
+	defer srv.Close()
+
+	ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"credential.https://git.example.com.vaultpath": []string{"/secret/data/git-lfs/"},
+	})), config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"VAULT_ADDR":  []string{srv.URL},
+		"VAULT_TOKEN": []string{"root-token"},
+	})))
+
+	creds, err := ctxt.vaultCredHelper.Fill(Creds{"protocol": "https", "host": "git.example.com", "username": "ci"})
+	assert.Nil(t, err)
+	assert.Equal(t, Creds{
+		"protocol":            "https",
+		"host":                "git.example.com",
+		"username":            "ci",
+		"password":            "t0k3n",
+		"password_expiry_utc": "1500003600",
+	}, creds)
+}
+
+func TestVaultCredentialHelperTransport(t *testing.T) {
+	srv, requests := newMockVault(t, map[string]string{
+		"/v1/secret/git-lfs": `{"data":{"password":"vault-pass"}}`,
+	})
+	defer srv.Close()
+
+	ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"credential.https://git.example.com.vaultpath": []string{"secret/git-lfs"},
+	})), config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"VAULT_ADDR":  []string{srv.URL},
+		"VAULT_TOKEN": []string{"root-token"},
+	})))
+	tr := &recordingTransport{}
+	var transportURLs []string
+	ctxt.SetTransport(func(u *url.URL) (http.RoundTripper, error) {
+		transportURLs = append(transportURLs, u.String())
+		return tr, nil
+	})
+
+	// the transport for the Vault server is created once, and used for
+	// each request
+	for i := 0; i < 2; i++ {
+		creds, err := ctxt.vaultCredHelper.Fill(Creds{"protocol": "https", "host": "git.example.com"})
+		require.Nil(t, err)
+		assert.Equal(t, "vault-pass", creds[CredsPassword])
+	}
+	assert.Equal(t, []string{srv.URL}, transportURLs)
+	assert.Equal(t, []string{srv.URL + "/v1/secret/git-lfs", srv.URL + "/v1/secret/git-lfs"}, tr.urls)
+	assert.Len(t, *requests, 2)
+}
+
+func TestVaultCredentialHelperWithoutVaultPath(t *testing.T) {
+	srv, requests := newMockVault(t, nil)
+	defer srv.Close()
+
+	ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(nil)), config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"VAULT_ADDR":  []string{srv.URL},
+		"VAULT_TOKEN": []string{"root-token"},
+	})))
+
+	what := Creds{"protocol": "https", "host": "git.example.com"}
+	_, err := ctxt.vaultCredHelper.Fill(what)
+	assert.Equal(t, credHelperNoOp, err)
+	assert.Equal(t, credHelperNoOp, ctxt.vaultCredHelper.Approve(what))
+	assert.Equal(t, credHelperNoOp, ctxt.vaultCredHelper.Reject(what))
+	assert.Empty(t, *requests)
+}
+
+func TestVaultCredentialHelperMissingSecret(t *testing.T) {
+	srv, _ := newMockVault(t, nil)
+	defer srv.Close()
+
+	ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"credential.https://git.example.com.vaultpath": []string{"secret/missing"},
+	})), config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"VAULT_ADDR":  []string{srv.URL},
+		"VAULT_TOKEN": []string{"root-token"},
+	})))
+
+	_, err := ctxt.vaultCredHelper.Fill(Creds{"protocol": "https", "host": "git.example.com"})
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "HTTP 404")
+	}
+}
+
+func TestVaultCredentialHelperReadOnlyByDefault(t *testing.T) {
+	srv, requests := newMockVault(t, nil)
+	defer srv.Close()
+
+	ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"credential.https://git.example.com.vaultpath": []string{"secret/git-lfs"},
+	})), config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"VAULT_ADDR":  []string{srv.URL},
+		"VAULT_TOKEN": []string{"root-token"},
+	})))
+
+	creds := Creds{"protocol": "https", "host": "git.example.com", "username": "u", "password": "p"}
+	assert.Nil(t, ctxt.vaultCredHelper.Approve(creds))
+	assert.Nil(t, ctxt.vaultCredHelper.Reject(creds))
+	assert.Empty(t, *requests)
+}
+
+func TestVaultCredentialHelperWrite(t *testing.T) {
+	srv, requests := newMockVault(t, nil)
+	defer srv.Close()
+
+	ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"credential.https://git.example.com.vaultpath":  []string{"secret/data/git-lfs"},
+		"credential.https://git.example.com.vaultwrite": []string{"true"},
+	})), config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"VAULT_ADDR":  []string{srv.URL},
+		"VAULT_TOKEN": []string{"root-token"},
+	})))
+
+	creds := Creds{"protocol": "https", "host": "git.example.com", "username": "u", "password": "p"}
+	assert.Nil(t, ctxt.vaultCredHelper.Approve(creds))
+	assert.Nil(t, ctxt.vaultCredHelper.Reject(creds))
+
+	require.Len(t, *requests, 2)
+	assert.Equal(t, "POST", (*requests)[0].Method)
+	assert.Equal(t, "/v1/secret/data/git-lfs", (*requests)[0].Path)
+	assert.Equal(t, map[string]interface{}{
+		"data": map[string]interface{}{"username": "u", "password": "p"},
+	}, (*requests)[0].Body)
+
+	// every version of the rejected secret is removed, not only the latest
+	assert.Equal(t, "DELETE", (*requests)[1].Method)
+	assert.Equal(t, "/v1/secret/metadata/git-lfs", (*requests)[1].Path)
+
+	// while a KV version 1 secret is deleted itself
+	ctxt = NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"credential.https://git.example.com.vaultpath":  []string{"secret/git-lfs"},
+		"credential.https://git.example.com.vaultwrite": []string{"true"},
+	})), config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"VAULT_ADDR":  []string{srv.URL},
+		"VAULT_TOKEN": []string{"root-token"},
+	})))
+	assert.Nil(t, ctxt.vaultCredHelper.Reject(creds))
+	require.Len(t, *requests, 3)
+	assert.Equal(t, "DELETE", (*requests)[2].Method)
+	assert.Equal(t, "/v1/secret/git-lfs", (*requests)[2].Path)
+}
+
+func TestVaultCredentialHelperInChain(t *testing.T) {
+	srv, _ := newMockVault(t, map[string]string{
+		"/v1/secret/git-lfs": `{"data":{"username":"vault-user","password":"vault-pass"}}`,
+	})
+	defer srv.Close()
+
+	ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"credential.https://git.example.com.vaultpath": []string{"secret/git-lfs"},
+	})), config.EnvironmentOf(config.MapFetcher(map[string][]string{
+		"VAULT_ADDR":  []string{srv.URL},
+		"VAULT_TOKEN": []string{"root-token"},
+	})))
+
+	u, _ := url.Parse("https://git.example.com/repo.git")
+	wrapper := ctxt.GetCredentialHelper(nil, u)
+	creds, err := wrapper.CredentialHelper.Fill(wrapper.Input)
+	assert.Nil(t, err)
+	assert.Equal(t, "vault-user", creds[CredsUsername])
+	assert.Equal(t, "vault-pass", creds[CredsPassword])
+}
+
+func TestVaultCredentialHelperDisabledWithoutAddr(t *testing.T) {
+	ctxt := NewCredentialHelperContext(config.EnvironmentOf(config.MapFetcher(nil)), config.EnvironmentOf(config.MapFetcher(nil)))
+	assert.Nil(t, ctxt.vaultCredHelper)
+}
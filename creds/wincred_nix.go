@@ -0,0 +1,7 @@
+// +build !windows
+
+package creds
+
+func newWinCredCredentialHelper() CredentialHelper {
+	return nil
+}
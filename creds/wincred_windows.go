@@ -0,0 +1,174 @@
+// +build windows
+
+package creds
+
+import (
+	"unsafe"
+
+	"github.com/rubyist/tracerx"
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modadvapi32 = windows.NewLazySystemDLL("advapi32.dll")
+
+	procCredReadW   = modadvapi32.NewProc("CredReadW")
+	procCredWriteW  = modadvapi32.NewProc("CredWriteW")
+	procCredDeleteW = modadvapi32.NewProc("CredDeleteW")
+	procCredFree    = modadvapi32.NewProc("CredFree")
+)
+
+const (
+	// winCredTypeGeneric = CRED_TYPE_GENERIC
+	winCredTypeGeneric = 1
+	// winCredPersistLocalMachine = CRED_PERSIST_LOCAL_MACHINE
+	winCredPersistLocalMachine = 2
+)
+
+// winCredential = CREDENTIALW structure
+//
+// https://docs.microsoft.com/windows/win32/api/wincred/ns-wincred-credentialw
+type winCredential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        windows.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// WinCredCredentialHelper implements the CredentialHelper type by storing
+// credentials directly in the Windows Credential Manager, without going
+// through 'git credential'.
+type WinCredCredentialHelper struct{}
+
+func newWinCredCredentialHelper() CredentialHelper {
+	return &WinCredCredentialHelper{}
+}
+
+// winCredTargetName returns the Credential Manager target name under which the
+// given Creds are stored.
+func winCredTargetName(what Creds) string {
+	return "git-lfs:" + credLookupKey(what)
+}
+
+// Name implements NamedCredentialHelper.Name.
+func (h *WinCredCredentialHelper) Name() string { return "wincred" }
+
+// Fill implements CredentialHelper.Fill by reading a generic credential from
+// the Windows Credential Manager. It returns credHelperNoOp if no credential is
+// stored for the given Creds, or if the stored username does not match the one
+// requested.
+func (h *WinCredCredentialHelper) Fill(what Creds) (Creds, error) {
+	target, err := windows.UTF16PtrFromString(winCredTargetName(what))
+	if err != nil {
+		return nil, err
+	}
+
+	var cred *winCredential
+	r, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(target)),
+		winCredTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if r == 0 {
+		if err == windows.ERROR_NOT_FOUND {
+			return nil, credHelperNoOp
+		}
+		return nil, err
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	username := utf16PtrToString(cred.UserName)
+	if given, ok := what[CredsUsername]; ok && given != username {
+		return nil, credHelperNoOp
+	}
+
+	var password string
+	if cred.CredentialBlobSize > 0 {
+		blob := (*[1 << 30]byte)(unsafe.Pointer(cred.CredentialBlob))[:cred.CredentialBlobSize:cred.CredentialBlobSize]
+		password = string(blob)
+	}
+
+	tracerx.Printf("wincred: git credential fill (%q, %q, %q)",
+		what[CredsProtocol], what[CredsHost], what[CredsPath])
+
+	creds := make(Creds)
+	for k, v := range what {
+		creds[k] = v
+	}
+	creds[CredsUsername] = username
+	creds[CredsPassword] = password
+	return creds, nil
+}
+
+// Approve implements CredentialHelper.Approve by adding or replacing a generic
+// credential in the Windows Credential Manager.
+func (h *WinCredCredentialHelper) Approve(what Creds) error {
+	password := what[CredsPassword]
+	if len(password) == 0 {
+		return credHelperNoOp
+	}
+
+	target, err := windows.UTF16PtrFromString(winCredTargetName(what))
+	if err != nil {
+		return err
+	}
+	username, err := windows.UTF16PtrFromString(what[CredsUsername])
+	if err != nil {
+		return err
+	}
+
+	blob := []byte(password)
+	cred := &winCredential{
+		Type:               winCredTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(blob)),
+		CredentialBlob:     &blob[0],
+		Persist:            winCredPersistLocalMachine,
+		UserName:           username,
+	}
+
+	tracerx.Printf("wincred: git credential approve (%q, %q, %q)",
+		what[CredsProtocol], what[CredsHost], what[CredsPath])
+
+	if r, _, err := procCredWriteW.Call(uintptr(unsafe.Pointer(cred)), 0); r == 0 {
+		return err
+	}
+	return nil
+}
+
+// Reject implements CredentialHelper.Reject by deleting the generic credential
+// from the Windows Credential Manager, if one is present.
+func (h *WinCredCredentialHelper) Reject(what Creds) error {
+	target, err := windows.UTF16PtrFromString(winCredTargetName(what))
+	if err != nil {
+		return err
+	}
+
+	tracerx.Printf("wincred: git credential reject (%q, %q, %q)",
+		what[CredsProtocol], what[CredsHost], what[CredsPath])
+
+	r, _, err := procCredDeleteW.Call(uintptr(unsafe.Pointer(target)),
+		winCredTypeGeneric, 0)
+	if r == 0 && err != windows.ERROR_NOT_FOUND {
+		return err
+	}
+	return nil
+}
+
+func utf16PtrToString(p *uint16) string {
+	if p == nil {
+		return ""
+	}
+
+	s := (*[1 << 29]uint16)(unsafe.Pointer(p))
+	n := 0
+	for s[n] != 0 {
+		n++
+	}
+	return windows.UTF16ToString(s[:n:n])
+}
@@ -0,0 +1,51 @@
+// +build windows
+
+package creds
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWinCredCredentialHelper(t *testing.T) {
+	helper := newWinCredCredentialHelper()
+	what := Creds{
+		CredsProtocol: "https",
+		CredsHost:     "wincred-test.git-lfs.invalid",
+		CredsPath:     "repo.git",
+	}
+
+	defer helper.Reject(what)
+
+	_, err := helper.Fill(what)
+	assert.Equal(t, credHelperNoOp, err)
+
+	approved := Creds{
+		CredsProtocol: "https",
+		CredsHost:     "wincred-test.git-lfs.invalid",
+		CredsPath:     "repo.git",
+		CredsUsername: "user",
+		CredsPassword: "pass",
+	}
+	assert.Nil(t, helper.Approve(approved))
+
+	out, err := helper.Fill(what)
+	assert.Nil(t, err)
+	assert.Equal(t, approved, out)
+
+	_, err = helper.Fill(Creds{
+		CredsProtocol: "https",
+		CredsHost:     "wincred-test.git-lfs.invalid",
+		CredsPath:     "repo.git",
+		CredsUsername: "other",
+	})
+	assert.Equal(t, credHelperNoOp, err)
+
+	assert.Nil(t, helper.Reject(what))
+	_, err = helper.Fill(what)
+	assert.Equal(t, credHelperNoOp, err)
+
+	// rejecting a missing credential is not an error
+	assert.Nil(t, helper.Reject(what))
+}
@@ -172,6 +172,36 @@ func IsRetriableLaterError(err error) (time.Time, bool) {
 	return time.Time{}, false
 }
 
+// IsKeyringLockedError indicates that a credential helper failed because the
+// system keyring it stores credentials in is locked.
+func IsKeyringLockedError(err error) bool {
+	if e, ok := err.(interface {
+		KeyringLockedError() bool
+	}); ok {
+		return e.KeyringLockedError()
+	}
+	if parent := parentOf(err); parent != nil {
+		return IsKeyringLockedError(parent)
+	}
+	return false
+}
+
+// IsCredentialHelperUnreachableError indicates that a credential helper failed
+// because a daemon it depends on, such as the one of 'git credential-cache',
+// could not be reached. Such errors are retriable, as the daemon may be
+// reachable again later.
+func IsCredentialHelperUnreachableError(err error) bool {
+	if e, ok := err.(interface {
+		CredentialHelperUnreachableError() bool
+	}); ok {
+		return e.CredentialHelperUnreachableError()
+	}
+	if parent := parentOf(err); parent != nil {
+		return IsCredentialHelperUnreachableError(parent)
+	}
+	return false
+}
+
 type errorWithCause interface {
 	Cause() error
 	StackTrace() errors.StackTrace
@@ -409,6 +439,38 @@ func NewRetriableError(err error) error {
 	return retriableError{newWrappedError(err, "")}
 }
 
+// Definitions for IsKeyringLockedError()
+
+type keyringLockedError struct {
+	*wrappedError
+}
+
+func (e keyringLockedError) KeyringLockedError() bool {
+	return true
+}
+
+func NewKeyringLockedError(err error) error {
+	return keyringLockedError{newWrappedError(err, "Keyring locked, please unlock it and try again")}
+}
+
+// Definitions for IsCredentialHelperUnreachableError()
+
+type credentialHelperUnreachableError struct {
+	*wrappedError
+}
+
+func (e credentialHelperUnreachableError) CredentialHelperUnreachableError() bool {
+	return true
+}
+
+func (e credentialHelperUnreachableError) RetriableError() bool {
+	return true
+}
+
+func NewCredentialHelperUnreachableError(err error) error {
+	return credentialHelperUnreachableError{newWrappedError(err, "Credential helper unreachable")}
+}
+
 func parentOf(err error) error {
 	type causer interface {
 		Cause() error
@@ -44,3 +44,19 @@ func TestCannotRetryOnGenericUrlError(t *testing.T) {
 	err := &url.Error{Err: errors.New("")}
 	assert.False(t, errors.IsRetriableError(err))
 }
+
+func TestCredentialHelperUnreachableError(t *testing.T) {
+	err := errors.NewCredentialHelperUnreachableError(errors.New("unable to connect to cache daemon"))
+	assert.True(t, errors.IsCredentialHelperUnreachableError(err))
+	assert.True(t, errors.IsCredentialHelperUnreachableError(errors.Wrap(err, "creds")))
+	assert.True(t, errors.IsRetriableError(err))
+	assert.False(t, errors.IsCredentialHelperUnreachableError(errors.New("unable to connect to cache daemon")))
+}
+
+func TestKeyringLockedError(t *testing.T) {
+	err := errors.NewKeyringLockedError(errors.New("locked collection"))
+	assert.True(t, errors.IsKeyringLockedError(err))
+	assert.True(t, errors.IsKeyringLockedError(errors.Wrap(err, "creds")))
+	assert.False(t, errors.IsKeyringLockedError(errors.New("locked collection")))
+	assert.Equal(t, "Keyring locked, please unlock it and try again: locked collection", err.Error())
+}
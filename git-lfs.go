@@ -12,7 +12,7 @@ import (
 
 func main() {
 	c := make(chan os.Signal)
-	signal.Notify(c, os.Interrupt, os.Kill)
+	signal.Notify(c, os.Interrupt, os.Kill, syscall.SIGTERM)
 
 	var once sync.Once
 
@@ -1,8 +1,13 @@
 package lfsapi
 
 import (
+	"bytes"
+	"crypto/x509"
 	"encoding/base64"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -65,27 +70,176 @@ func (c *Client) doWithAuth(remote string, access creds.Access, req *http.Reques
 	}
 
 	res, err := c.doWithCreds(req, credWrapper, access, via)
+	if c.otpRequired(res) && credWrapper.Creds != nil {
+		res, err = c.doWithOTP(req, credWrapper, access, via, res, err)
+	}
+	if proxyAuthRequired(res, err) {
+		res, err = c.doWithProxyCreds(req, credWrapper, access, via, res, err)
+	}
+	bodyRejected := err == nil && c.authFailureBodyMatches(res)
+	if bodyRejected {
+		tracerx.Printf("api: response body from %s matches lfs.authFailureBodyPattern", creds.SanitizeURL(req.URL))
+		err = errors.NewAuthError(errors.Errorf("response body from %s indicates an authentication failure", creds.SanitizeURL(req.URL)))
+	}
 	if err != nil {
 		if errors.IsAuthError(err) {
 			newAccess := access.Upgrade(getAuthAccess(res))
 			if newAccess.Mode() != access.Mode() {
 				c.Endpoints.SetAccess(newAccess)
 			}
+		}
 
-			if credWrapper.Creds != nil {
-				req.Header.Del("Authorization")
-				credWrapper.CredentialHelper.Reject(credWrapper.Creds)
-			}
+		if credWrapper.Creds != nil && (bodyRejected || credsRejected(res)) {
+			req.Header.Del("Authorization")
+			c.discardDeferredApproval(credWrapper)
+			credWrapper.CredentialHelper.Reject(credWrapper.Creds)
 		}
 	}
 
-	if res != nil && res.StatusCode < 300 && res.StatusCode > 199 {
-		credWrapper.CredentialHelper.Approve(credWrapper.Creds)
+	if !bodyRejected && res != nil && res.StatusCode < 300 && res.StatusCode > 199 {
+		c.approve(req, credWrapper)
 	}
 
 	return res, err
 }
 
+// approve approves the credentials in the given wrapper, or, if
+// "lfs.credentialdeferapproval" is enabled and the request carries pending
+// approvals from WithDeferredApprovals, defers doing so until they are
+// committed.
+func (c *Client) approve(req *http.Request, credWrapper creds.CredentialHelperWrapper) {
+	pending := deferredApprovals(req)
+	if !c.deferApprovals || pending == nil || credWrapper.Creds == nil {
+		credWrapper.CredentialHelper.Approve(credWrapper.Creds)
+		return
+	}
+
+	pending.Defer(credWrapper)
+}
+
+// discardDeferredApproval forgets any deferred approval of the credentials in
+// the given wrapper, since they have since been rejected.
+func (c *Client) discardDeferredApproval(credWrapper creds.CredentialHelperWrapper) {
+	c.credContext.DiscardApproval(credWrapper.Creds)
+}
+
+// NewDeferredApprovals returns an empty set of pending approvals, for a
+// transfer to store on its requests with WithDeferredApprovals. It should
+// be committed once the transfer has completed successfully, which approves
+// the credentials used once per host, however many requests used them, or
+// discarded if it failed.
+func (c *Client) NewDeferredApprovals() *creds.PendingApprovals {
+	return c.credContext.NewPendingApprovals()
+}
+
+// CredentialHandoff returns the value of the creds.CredentialHandoffEnv
+// environment variable for the Git LFS processes run by this one, listing the
+// credentials this client has filled, or the empty string if
+// "lfs.credentialhandoff" is disabled.
+func (c *Client) CredentialHandoff() string {
+	return c.credContext.HandoffEnv()
+}
+
+// CachedCredentials returns a copy of the credentials cached in memory for the
+// given URL, if any, such as to refresh an OAuth access token with their
+// OAuthRefreshToken before their PasswordExpiry, rather than prompting again.
+// Credentials filled by c.Credentials, if set, are not cached.
+func (c *Client) CachedCredentials(u *url.URL) (creds.Creds, bool) {
+	return c.credContext.CachedCreds(u)
+}
+
+// defaultOTPHeader is the header with which a server asks for a one-time code,
+// with a value such as "required; app", and which carries the code, if
+// "lfs.credentialotpheader" is not set.
+const defaultOTPHeader = "X-GitHub-OTP"
+
+// otpRequired returns whether the given response asks for a one-time code in
+// addition to the credentials sent, and "lfs.credentialotp" is enabled.
+func (c *Client) otpRequired(res *http.Response) bool {
+	if len(c.otpHeader) == 0 || res == nil || res.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+	value := strings.TrimSpace(res.Header.Get(c.otpHeader))
+	return strings.HasPrefix(strings.ToLower(value), "required")
+}
+
+// doWithOTP prompts for a one-time code for the credentials in the given
+// wrapper, and resends the request with it and them. If the code cannot be
+// prompted for, the given response and error are returned as they are.
+func (c *Client) doWithOTP(req *http.Request, credWrapper creds.CredentialHelperWrapper, access creds.Access, via []*http.Request, res *http.Response, err error) (*http.Response, error) {
+	tracerx.Printf("api: one-time code required for %s", creds.SanitizeURL(credWrapper.Url))
+	code, otpErr := c.credContext.FillOTP(credWrapper.Url)
+	if otpErr != nil {
+		tracerx.Printf("api: %s", otpErr)
+		return res, err
+	}
+
+	body, bodyErr := rewoundRequestBody(req)
+	if bodyErr != nil {
+		tracerx.Printf("api: cannot resend request with one-time code: %s", bodyErr)
+		return res, err
+	}
+	if res.Body != nil {
+		res.Body.Close()
+	}
+
+	req.Body = body
+	req.Header.Set(c.otpHeader, code)
+	defer req.Header.Del(c.otpHeader)
+	return c.doWithCreds(req, credWrapper, access, via)
+}
+
+// credsRejected returns whether the given response indicates that the server
+// refused the credentials sent with the request. Only a 401 or 403 response
+// counts as a rejection; server errors and failed connections (for which res is
+// nil) do not, since the credentials may well be valid.
+func credsRejected(res *http.Response) bool {
+	if res == nil {
+		return false
+	}
+
+	switch res.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return true
+	}
+	return false
+}
+
+// authFailureBodyLimit is the number of bytes of a successful response's body
+// which are matched against "lfs.authfailurebodypattern", so that large
+// responses, such as objects being downloaded, are not read into memory.
+const authFailureBodyLimit = 64 * 1024
+
+// authFailureBodyMatches returns whether the body of the given successful
+// response matches "lfs.authfailurebodypattern", as some misbehaving proxies
+// report authentication failures with a 2xx status. The start of the body
+// which is read is put back, so that the response may still be read in full.
+func (c *Client) authFailureBodyMatches(res *http.Response) bool {
+	if c.authFailureBody == nil || res == nil || res.Body == nil {
+		return false
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return false
+	}
+
+	prefix, err := ioutil.ReadAll(io.LimitReader(res.Body, authFailureBodyLimit))
+	res.Body = &prefixedReadCloser{
+		Reader: io.MultiReader(bytes.NewReader(prefix), res.Body),
+		Closer: res.Body,
+	}
+	if err != nil {
+		return false
+	}
+	return c.authFailureBody.Match(prefix)
+}
+
+// prefixedReadCloser is the body of a response, part of which was read, with
+// that part put back in front of the rest.
+type prefixedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
 func (c *Client) doWithCreds(req *http.Request, credWrapper creds.CredentialHelperWrapper, access creds.Access, via []*http.Request) (*http.Response, error) {
 	if access.Mode() == creds.NTLMAccess {
 		return c.doWithNTLM(req, credWrapper)
@@ -101,6 +255,7 @@ func (c *Client) doWithCreds(req *http.Request, credWrapper creds.CredentialHelp
 	}
 
 	redirectedReq, res, err := c.client.DoWithRedirect(client, req, "", via)
+	c.recordTLSPeerName(req, res)
 	if err != nil || res != nil {
 		return res, err
 	}
@@ -156,11 +311,21 @@ func (c *Client) getCreds(remote string, access creds.Access, req *http.Request)
 			return creds.CredentialHelperWrapper{CredentialHelper: creds.NullCreds, Input: nil, Url: nil, Creds: nil}, nil
 		}
 
-		credWrapper := c.getGitCredsWrapper(ef, req, credsURL)
+		credsURL = c.credURLForTLSPeer(credsURL)
+
+		credWrapper := c.getGitCredsWrapper(ef, remote, req, credsURL)
 		err = credWrapper.FillCreds()
 		if err == nil {
-			tracerx.Printf("Filled credentials for %s", credsURL)
-			setRequestAuth(req, credWrapper.Creds["username"], credWrapper.Creds["password"])
+			tracerx.Printf("Filled credentials for %s", creds.SanitizeURL(credsURL))
+
+			var usable creds.Creds
+			if usable, err = credWrapper.UsableCreds(); err == nil {
+				err = creds.ValidateCreds(usable)
+			}
+			if err == nil {
+				setRequestAuthFromCreds(req, usable)
+				redirectRequestForCreds(req, credWrapper)
+			}
 		}
 		return credWrapper, err
 	}
@@ -173,12 +338,81 @@ func (c *Client) getCreds(remote string, access creds.Access, req *http.Request)
 	}
 
 	// NTLM uses creds to create the session
-	credWrapper := c.getGitCredsWrapper(ef, req, credsURL)
+	credWrapper := c.getGitCredsWrapper(ef, remote, req, credsURL)
 	return credWrapper, err
 }
 
-func (c *Client) getGitCredsWrapper(ef EndpointFinder, req *http.Request, u *url.URL) creds.CredentialHelperWrapper {
-	return c.credContext.GetCredentialHelper(c.Credentials, u)
+func (c *Client) getGitCredsWrapper(ef EndpointFinder, remote string, req *http.Request, u *url.URL) creds.CredentialHelperWrapper {
+	hints := credentialHints(req)
+	hints.Remote = remote
+	return c.credContext.GetCredentialHelperWithHints(c.Credentials, u, hints)
+}
+
+// recordTLSPeerName remembers the primary name of the verified certificate
+// presented in response to the given request, if any, for credURLForTLSPeer.
+func (c *Client) recordTLSPeerName(req *http.Request, res *http.Response) {
+	if res == nil || res.TLS == nil || len(res.TLS.VerifiedChains) == 0 || len(res.TLS.VerifiedChains[0]) == 0 {
+		return
+	}
+	name := certPrimaryName(res.TLS.VerifiedChains[0][0])
+	if len(name) == 0 {
+		return
+	}
+
+	c.tlsPeerNamesMu.Lock()
+	defer c.tlsPeerNamesMu.Unlock()
+
+	if c.tlsPeerNames == nil {
+		c.tlsPeerNames = make(map[string]string)
+	}
+	c.tlsPeerNames[req.URL.Host] = name
+}
+
+// credURLForTLSPeer returns the given URL with its host name replaced by the
+// primary name of the verified certificate its host last presented, if
+// "credential.<url>.useTLSPeerName" is enabled and the two differ, so that
+// credentials are filled for the name of the server actually reached, such as
+// in split-horizon DNS setups. The certificate is only known once a response
+// has been received from the host, so the first request to it which needs
+// credentials uses those for the URL's own host.
+func (c *Client) credURLForTLSPeer(u *url.URL) *url.URL {
+	if u.Scheme != "https" || c.urlConfig == nil {
+		return u
+	}
+	if !c.urlConfig.Bool("credential", u.String(), "usetlspeername", false) {
+		return u
+	}
+
+	c.tlsPeerNamesMu.Lock()
+	name, ok := c.tlsPeerNames[u.Host]
+	c.tlsPeerNamesMu.Unlock()
+
+	if !ok || strings.EqualFold(name, u.Hostname()) {
+		return u
+	}
+
+	peer := *u
+	if port := u.Port(); len(port) > 0 {
+		peer.Host = net.JoinHostPort(name, port)
+	} else {
+		peer.Host = name
+	}
+	tracerx.Printf("creds: filling credentials for %s, the TLS peer of %s", creds.SanitizeURL(&peer), creds.SanitizeURL(u))
+	return &peer
+}
+
+// certPrimaryName returns the primary name of the given certificate: its first
+// DNS subject alternative name, or otherwise its common name. Wildcard names
+// name no particular host, so none is returned for them.
+func certPrimaryName(cert *x509.Certificate) string {
+	name := cert.Subject.CommonName
+	if len(cert.DNSNames) > 0 {
+		name = cert.DNSNames[0]
+	}
+	if strings.HasPrefix(name, "*.") {
+		return ""
+	}
+	return name
 }
 
 func getCredURLForAPI(ef EndpointFinder, operation, remote string, apiEndpoint lfshttp.Endpoint, req *http.Request) (*url.URL, error) {
@@ -267,6 +501,29 @@ func hasScheme(what string) bool {
 	return false
 }
 
+// redirectRequestForCreds sends the given request to the URL a credential
+// helper redirected its credentials to, if any, keeping the part of the
+// request's path below the path of the URL the credentials were filled for.
+func redirectRequestForCreds(req *http.Request, credWrapper creds.CredentialHelperWrapper) {
+	effective := credWrapper.EffectiveURL()
+	if effective == nil || effective == credWrapper.Url {
+		return
+	}
+
+	u := *req.URL
+	u.Scheme = effective.Scheme
+	u.Host = effective.Host
+	prefix := strings.TrimSuffix(credWrapper.Url.Path, "/")
+	if effective.Path != credWrapper.Url.Path && strings.HasPrefix(req.URL.Path, prefix) {
+		u.Path = strings.TrimSuffix(effective.Path, "/") + strings.TrimPrefix(req.URL.Path, prefix)
+		u.RawPath = ""
+	}
+
+	tracerx.Printf("creds: sending request for %s to %s", creds.SanitizeURL(req.URL), creds.SanitizeURL(&u))
+	req.URL = &u
+	req.Host = u.Host
+}
+
 func requestHasAuth(req *http.Request) bool {
 	// The "Authorization" string constant is safe, since we assume that all
 	// request headers have been canonicalized.
@@ -291,6 +548,24 @@ func setRequestAuthFromURL(req *http.Request, u *url.URL) bool {
 	return false
 }
 
+// setRequestAuthFromCreds sets the Authorization header from the given Creds.
+// If a credential helper returned an "authtype" and "credential", they are used
+// as is; otherwise Basic authentication with the username and password is used.
+// If an "authtype" other than Basic was forced with "credential.<url>.authtype",
+// but the helper returned only a password, the password is sent with it.
+func setRequestAuthFromCreds(req *http.Request, c creds.Creds) {
+	authtype, credential := c[creds.CredsAuthtype], c[creds.CredsCredential]
+	if len(credential) == 0 && len(authtype) > 0 && !strings.EqualFold(authtype, "Basic") {
+		credential = c[creds.CredsPassword]
+	}
+	if len(authtype) > 0 && len(credential) > 0 {
+		req.Header.Set("Authorization", fmt.Sprintf("%s %s", authtype, credential))
+		return
+	}
+
+	setRequestAuth(req, c[creds.CredsUsername], c[creds.CredsPassword])
+}
+
 func setRequestAuth(req *http.Request, user, pass string) {
 	// better not be NTLM!
 	if len(user) == 0 && len(pass) == 0 {
@@ -3,12 +3,19 @@ package lfsapi
 import (
 	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/git-lfs/git-lfs/creds"
 	"github.com/git-lfs/git-lfs/errors"
@@ -176,6 +183,89 @@ func TestDoWithAuthReject(t *testing.T) {
 	assert.EqualValues(t, 3, called)
 }
 
+func TestDoWithAuthRejectWithoutCache(t *testing.T) {
+	var called uint32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&called, 1)
+
+		w.Header().Set("Lfs-Authenticate", "Basic")
+		if req.Header.Get("Authorization") != basicAuth("user", "pass") {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	invalidCreds := creds.Creds(map[string]string{
+		"username": "user",
+		"password": "wrong_pass",
+		"path":     "",
+		"protocol": "http",
+		"host":     srv.Listener.Addr().String(),
+	})
+
+	cred := newMockCredentialHelper()
+	cred.Approve(invalidCreds)
+
+	c, err := NewClient(lfshttp.NewContext(git.NewReadOnlyConfig("", ""),
+		nil, map[string]string{
+			"lfs.url":              srv.URL,
+			"lfs.cachecredentials": "false",
+		},
+	))
+	require.Nil(t, err)
+	c.Credentials = cred
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	require.Nil(t, err)
+
+	res, err := c.DoWithAuth("", c.Endpoints.AccessFor(srv.URL), req)
+	require.Nil(t, err)
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.False(t, cred.IsApproved(invalidCreds))
+	assert.True(t, cred.IsApproved(creds.Creds(map[string]string{
+		"password": "pass",
+		"path":     "",
+		"protocol": "http",
+		"host":     srv.Listener.Addr().String(),
+	})))
+	assert.EqualValues(t, 3, called)
+}
+
+func TestDoWithAuthValueTransform(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	cred := newMockCredentialHelper()
+	c, err := NewClient(lfshttp.NewContext(git.NewReadOnlyConfig("", ""),
+		nil, map[string]string{
+			"lfs.url": srv.URL,
+			"credential." + srv.URL + ".valuetransform": "authtype:Bearer",
+		},
+	))
+	require.Nil(t, err)
+	c.Credentials = cred
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	require.Nil(t, err)
+
+	res, err := c.DoWithAuth("", creds.NewAccess(creds.BasicAccess, srv.URL), req)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// the untransformed credentials are approved
+	assert.True(t, cred.IsApproved(creds.Creds{
+		"protocol": "http",
+		"host":     srv.Listener.Addr().String(),
+		"password": "pass",
+	}))
+}
+
 func TestDoWithAuthNoRetry(t *testing.T) {
 	var called uint32
 
@@ -287,6 +377,558 @@ func TestDoAPIRequestWithAuth(t *testing.T) {
 	assert.EqualValues(t, 2, called)
 }
 
+func TestCredsRejected(t *testing.T) {
+	tests := map[int]bool{
+		http.StatusOK:                  false,
+		http.StatusUnauthorized:        true,
+		http.StatusForbidden:           true,
+		http.StatusNotFound:            false,
+		http.StatusInternalServerError: false,
+		http.StatusServiceUnavailable:  false,
+	}
+
+	for status, expected := range tests {
+		assert.Equal(t, expected, credsRejected(&http.Response{StatusCode: status}), "status %d", status)
+	}
+	assert.False(t, credsRejected(nil))
+}
+
+func TestDoWithAuthRejectStatus(t *testing.T) {
+	tests := map[int]bool{
+		http.StatusUnauthorized:       true,
+		http.StatusForbidden:          true,
+		http.StatusServiceUnavailable: false,
+	}
+
+	for status, rejected := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(status)
+		}))
+
+		approved := creds.Creds(map[string]string{
+			"username": "user",
+			"password": "pass",
+			"protocol": "http",
+			"host":     srv.Listener.Addr().String(),
+		})
+
+		cred := newMockCredentialHelper()
+		cred.Approve(approved)
+
+		c, err := NewClient(lfshttp.NewContext(git.NewReadOnlyConfig("", ""),
+			nil, map[string]string{
+				"lfs.url": srv.URL + "/repo/lfs",
+			},
+		))
+		require.Nil(t, err)
+		c.Credentials = cred
+		c.Endpoints.SetAccess(creds.NewAccess(creds.BasicAccess, srv.URL+"/repo/lfs"))
+
+		req, err := http.NewRequest("GET", srv.URL+"/repo/lfs/foo", nil)
+		require.Nil(t, err)
+
+		_, err = c.DoWithAuthNoRetry("", c.Endpoints.AccessFor(srv.URL+"/repo/lfs"), req)
+		assert.NotNil(t, err, "status %d", status)
+		assert.Equal(t, !rejected, cred.IsApproved(approved), "status %d", status)
+
+		srv.Close()
+	}
+}
+
+func TestDoWithAuthDeferApproval(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	cred := newMockCredentialHelper()
+	c, err := NewClient(lfshttp.NewContext(git.NewReadOnlyConfig("", ""),
+		nil, map[string]string{
+			"lfs.url":                     srv.URL + "/repo/lfs",
+			"lfs.credentialdeferapproval": "true",
+		},
+	))
+	require.Nil(t, err)
+	c.Credentials = cred
+	c.Endpoints.SetAccess(creds.NewAccess(creds.BasicAccess, srv.URL+"/repo/lfs"))
+	pending := c.NewDeferredApprovals()
+
+	req, err := http.NewRequest("GET", srv.URL+"/repo/lfs/foo", nil)
+	require.Nil(t, err)
+
+	_, err = c.DoWithAuthNoRetry("", c.Endpoints.AccessFor(srv.URL+"/repo/lfs"), WithDeferredApprovals(req, pending))
+	require.Nil(t, err)
+
+	filled := creds.Creds{
+		"username": "user",
+		"password": "pass",
+		"protocol": "http",
+		"host":     srv.Listener.Addr().String(),
+	}
+	assert.False(t, cred.IsApproved(filled))
+
+	pending.Commit()
+	assert.True(t, cred.IsApproved(filled))
+}
+
+func TestDoWithAuthDeferApprovalPerTransfer(t *testing.T) {
+	var servers []*httptest.Server
+	for i := 0; i < 2; i++ {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer srv.Close()
+		servers = append(servers, srv)
+	}
+
+	cred := &approveCountingCredentialHelper{newMockCredentialHelper(), make(map[string]int)}
+	c, err := NewClient(lfshttp.NewContext(git.NewReadOnlyConfig("", ""),
+		nil, map[string]string{
+			"lfs.credentialdeferapproval": "true",
+		},
+	))
+	require.Nil(t, err)
+	c.Credentials = cred
+
+	// two transfers sharing the client defer approvals separately
+	succeeded := c.NewDeferredApprovals()
+	failed := c.NewDeferredApprovals()
+	for i, pending := range []*creds.PendingApprovals{succeeded, failed} {
+		srv := servers[i]
+		c.Endpoints.SetAccess(creds.NewAccess(creds.BasicAccess, srv.URL+"/repo/lfs"))
+		req, err := http.NewRequest("GET", srv.URL+"/repo/lfs/foo", nil)
+		require.Nil(t, err)
+
+		_, err = c.DoWithAuthNoRetry("", c.Endpoints.AccessFor(srv.URL+"/repo/lfs"), WithDeferredApprovals(req, pending))
+		require.Nil(t, err)
+	}
+
+	// a request outside of any transfer is approved at once
+	c.Endpoints.SetAccess(creds.NewAccess(creds.BasicAccess, servers[1].URL+"/repo/lfs"))
+	req, err := http.NewRequest("GET", servers[1].URL+"/repo/lfs/foo", nil)
+	require.Nil(t, err)
+	_, err = c.DoWithAuthNoRetry("", c.Endpoints.AccessFor(servers[1].URL+"/repo/lfs"), req)
+	require.Nil(t, err)
+	assert.Equal(t, map[string]int{servers[1].Listener.Addr().String(): 1}, cred.approves)
+
+	failed.Discard()
+	succeeded.Commit()
+	assert.Equal(t, map[string]int{
+		servers[0].Listener.Addr().String(): 1,
+		servers[1].Listener.Addr().String(): 1,
+	}, cred.approves)
+}
+
+// approveCountingCredentialHelper is a mockCredentialHelper which counts the
+// approvals for each host.
+type approveCountingCredentialHelper struct {
+	*mockCredentialHelper
+	approves map[string]int
+}
+
+func (h *approveCountingCredentialHelper) Approve(c creds.Creds) error {
+	h.approves[c["host"]]++
+	return h.mockCredentialHelper.Approve(c)
+}
+
+func TestDoWithAuthDeferApprovalOncePerHost(t *testing.T) {
+	var servers []*httptest.Server
+	for i := 0; i < 2; i++ {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer srv.Close()
+		servers = append(servers, srv)
+	}
+
+	cred := &approveCountingCredentialHelper{newMockCredentialHelper(), make(map[string]int)}
+	c, err := NewClient(lfshttp.NewContext(git.NewReadOnlyConfig("", ""),
+		nil, map[string]string{
+			"lfs.credentialdeferapproval": "true",
+		},
+	))
+	require.Nil(t, err)
+	c.Credentials = cred
+	pending := c.NewDeferredApprovals()
+
+	for i := 0; i < 5; i++ {
+		for _, srv := range servers {
+			c.Endpoints.SetAccess(creds.NewAccess(creds.BasicAccess, srv.URL+"/repo/lfs"))
+			req, err := http.NewRequest("GET", srv.URL+"/repo/lfs/foo", nil)
+			require.Nil(t, err)
+
+			_, err = c.DoWithAuthNoRetry("", c.Endpoints.AccessFor(srv.URL+"/repo/lfs"), WithDeferredApprovals(req, pending))
+			require.Nil(t, err)
+		}
+	}
+	assert.Empty(t, cred.approves)
+
+	pending.Commit()
+	assert.Equal(t, map[string]int{
+		servers[0].Listener.Addr().String(): 1,
+		servers[1].Listener.Addr().String(): 1,
+	}, cred.approves)
+}
+
+// rejectCountingCredentialHelper is a mockCredentialHelper which counts the
+// credentials rejected.
+type rejectCountingCredentialHelper struct {
+	*mockCredentialHelper
+	rejects int
+}
+
+func (h *rejectCountingCredentialHelper) Reject(c creds.Creds) error {
+	h.rejects++
+	return h.mockCredentialHelper.Reject(c)
+}
+
+func TestDoWithAuthFailureBodyPattern(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		assert.NotEmpty(t, req.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusOK)
+		if requests == 1 {
+			w.Write([]byte("<html>Please sign in</html>"))
+		} else {
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer srv.Close()
+
+	cred := &rejectCountingCredentialHelper{mockCredentialHelper: newMockCredentialHelper()}
+	c, err := NewClient(lfshttp.NewContext(git.NewReadOnlyConfig("", ""),
+		nil, map[string]string{
+			"lfs.url":                    srv.URL + "/repo/lfs",
+			"lfs.authfailurebodypattern": "(?i)please sign in",
+		},
+	))
+	require.Nil(t, err)
+	c.Credentials = cred
+	c.Endpoints.SetAccess(creds.NewAccess(creds.BasicAccess, srv.URL+"/repo/lfs"))
+
+	req, err := http.NewRequest("GET", srv.URL+"/repo/lfs/foo", nil)
+	require.Nil(t, err)
+
+	res, err := c.DoWithAuth("", c.Endpoints.AccessFor(srv.URL+"/repo/lfs"), req)
+	require.Nil(t, err)
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, 1, cred.rejects)
+
+	// the body of a response which does not match is read in full
+	body, err := ioutil.ReadAll(res.Body)
+	require.Nil(t, err)
+	assert.Equal(t, "ok", string(body))
+	res.Body.Close()
+
+	assert.True(t, cred.IsApproved(creds.Creds{
+		"username": "user",
+		"password": "pass",
+		"protocol": "http",
+		"host":     srv.Listener.Addr().String(),
+	}))
+}
+
+func TestSetRequestAuthFromCreds(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://example.com", nil)
+	require.Nil(t, err)
+
+	setRequestAuthFromCreds(req, creds.Creds{"username": "user", "password": "pass"})
+	assert.Equal(t, "Basic "+base64.StdEncoding.EncodeToString([]byte("user:pass")), req.Header.Get("Authorization"))
+
+	setRequestAuthFromCreds(req, creds.Creds{"authtype": "Bearer", "credential": "token", "username": "user"})
+	assert.Equal(t, "Bearer token", req.Header.Get("Authorization"))
+
+	// a forced authtype, with only a password filled
+	setRequestAuthFromCreds(req, creds.Creds{"authtype": "Bearer", "username": "user", "password": "pass"})
+	assert.Equal(t, "Bearer pass", req.Header.Get("Authorization"))
+
+	setRequestAuthFromCreds(req, creds.Creds{"authtype": "basic", "username": "user", "password": "pass"})
+	assert.Equal(t, "Basic "+base64.StdEncoding.EncodeToString([]byte("user:pass")), req.Header.Get("Authorization"))
+}
+
+func TestDoWithAuthForcedAuthtype(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	cred := newMockCredentialHelper()
+	c, err := NewClient(lfshttp.NewContext(git.NewReadOnlyConfig("", ""),
+		nil, map[string]string{
+			"lfs.url":                             srv.URL,
+			"credential." + srv.URL + ".authtype": "Bearer",
+		},
+	))
+	require.Nil(t, err)
+	c.Credentials = cred
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	require.Nil(t, err)
+
+	res, err := c.DoWithAuth("", creds.NewAccess(creds.BasicAccess, srv.URL), req)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	approved := cred.Approved[credsToKey(creds.Creds{"protocol": "http", "host": srv.Listener.Addr().String()})]
+	assert.Equal(t, "Bearer", approved["authtype"])
+}
+
+// passwordOnlyCredHelper fills only a password, as some helpers do.
+type passwordOnlyCredHelper struct {
+	*mockCredentialHelper
+}
+
+func (h *passwordOnlyCredHelper) Fill(input creds.Creds) (creds.Creds, error) {
+	return creds.Creds{"protocol": input["protocol"], "host": input["host"], "password": "pass"}, nil
+}
+
+func TestDoWithAuthIncompleteCreds(t *testing.T) {
+	var called uint32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&called, 1)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(lfshttp.NewContext(git.NewReadOnlyConfig("", ""),
+		nil, map[string]string{
+			"lfs.url": srv.URL,
+		},
+	))
+	require.Nil(t, err)
+	c.Credentials = &passwordOnlyCredHelper{newMockCredentialHelper()}
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	require.Nil(t, err)
+
+	_, err = c.DoWithAuth("", creds.NewAccess(creds.BasicAccess, srv.URL), req)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "have no username, which Basic authentication requires")
+	}
+	assert.EqualValues(t, 0, called)
+}
+
+// redirectingCredHelper fills credentials as a mockCredentialHelper does,
+// along with a "url" redirecting requests elsewhere.
+type redirectingCredHelper struct {
+	*mockCredentialHelper
+	url string
+}
+
+func (h *redirectingCredHelper) Fill(input creds.Creds) (creds.Creds, error) {
+	output, err := h.mockCredentialHelper.Fill(input)
+	if err == nil {
+		output[creds.CredsURL] = h.url
+	}
+	return output, err
+}
+
+func TestDoWithAuthCredentialHelperURL(t *testing.T) {
+	var called uint32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&called, 1)
+		assert.Equal(t, "/mirror/lfs/objects/batch", req.URL.Path)
+		assert.Equal(t, basicAuth("user", "pass"), req.Header.Get("Authorization"))
+	}))
+	defer srv.Close()
+
+	cred := &redirectingCredHelper{newMockCredentialHelper(), srv.URL + "/mirror/lfs"}
+	c, err := NewClient(lfshttp.NewContext(git.NewReadOnlyConfig("", ""),
+		nil, map[string]string{
+			"lfs.url": "http://lfs.example.invalid/repo/lfs",
+		},
+	))
+	require.Nil(t, err)
+	c.Credentials = cred
+
+	req, err := http.NewRequest("GET", "http://lfs.example.invalid/repo/lfs/objects/batch", nil)
+	require.Nil(t, err)
+
+	res, err := c.DoWithAuth("", creds.NewAccess(creds.BasicAccess, "http://lfs.example.invalid/repo/lfs"), req)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.EqualValues(t, 1, called)
+	assert.Equal(t, srv.Listener.Addr().String(), req.URL.Host)
+
+	// the credentials are approved for the URL they were redirected to
+	assert.True(t, cred.IsApproved(creds.Creds{
+		"protocol": "http",
+		"host":     srv.Listener.Addr().String(),
+		"path":     "mirror/lfs",
+		"password": "pass",
+	}))
+}
+
+func TestDoWithAuthKeepsOAuthRefreshToken(t *testing.T) {
+	var called uint32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&called, 1)
+		assert.Equal(t, basicAuth("user", "access"), req.Header.Get("Authorization"))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "oauth-helper")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	expiry := time.Now().Add(time.Hour).Unix()
+	program := filepath.Join(dir, "git-credential-oauth")
+	script := fmt.Sprintf("#!/bin/sh\nwhile read line; do :; done\n"+
+		"if [ \"$1\" = get ]; then\n"+
+		"echo username=user\necho password=access\necho oauth_refresh_token=refresh\necho password_expiry_utc=%d\n"+
+		"fi\n", expiry)
+	require.Nil(t, ioutil.WriteFile(program, []byte(script), 0755))
+
+	c, err := NewClient(lfshttp.NewContext(git.NewReadOnlyConfig("", ""),
+		nil, map[string]string{
+			"lfs.url":                     srv.URL + "/repo/lfs",
+			"lfs.credentialhelperprogram": program,
+		},
+	))
+	require.Nil(t, err)
+
+	req, err := http.NewRequest("GET", srv.URL+"/repo/lfs/objects/batch", nil)
+	require.Nil(t, err)
+
+	res, err := c.DoWithAuth("", creds.NewAccess(creds.BasicAccess, srv.URL+"/repo/lfs"), req)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.EqualValues(t, 1, called)
+
+	u, err := url.Parse(srv.URL + "/repo/lfs")
+	require.Nil(t, err)
+	cached, ok := c.CachedCredentials(u)
+	require.True(t, ok)
+	assert.Equal(t, "refresh", cached.OAuthRefreshToken())
+	until, ok := cached.PasswordExpiry()
+	assert.True(t, ok)
+	assert.Equal(t, expiry, until.Unix())
+}
+
+func TestDoWithAuthTLSPeerName(t *testing.T) {
+	// The test server's certificate is for "example.com", though it is
+	// reached as 127.0.0.1.
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if len(req.Header.Get("Authorization")) == 0 {
+			w.Header().Set("Lfs-Authenticate", "Basic")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, basicAuth("user", "pass"), req.Header.Get("Authorization"))
+	}))
+	defer srv.Close()
+
+	cafile, err := ioutil.TempFile("", "lfs-tls-peer-ca")
+	require.Nil(t, err)
+	defer os.Remove(cafile.Name())
+	require.Nil(t, pem.Encode(cafile, &pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+	require.Nil(t, cafile.Close())
+
+	_, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	require.Nil(t, err)
+
+	for _, enabled := range []bool{false, true} {
+		cred := newMockCredentialHelper()
+		c, err := NewClient(lfshttp.NewContext(git.NewReadOnlyConfig("", ""),
+			nil, map[string]string{
+				"lfs.url":                   srv.URL + "/repo/lfs",
+				"http.sslcainfo":            cafile.Name(),
+				"credential.usetlspeername": fmt.Sprintf("%t", enabled),
+			},
+		))
+		require.Nil(t, err)
+		c.Credentials = cred
+
+		req, err := http.NewRequest("GET", srv.URL+"/repo/lfs/foo", nil)
+		require.Nil(t, err)
+
+		res, err := c.DoWithAuth("", c.Endpoints.AccessFor(srv.URL+"/repo/lfs"), req)
+		require.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+
+		// the request is still sent to the URL's host
+		assert.Equal(t, srv.Listener.Addr().String(), req.URL.Host)
+
+		peer := creds.Creds{"protocol": "https", "host": net.JoinHostPort("example.com", port), "password": "pass"}
+		host := creds.Creds{"protocol": "https", "host": srv.Listener.Addr().String(), "password": "pass"}
+		assert.Equal(t, enabled, cred.IsApproved(peer))
+		assert.Equal(t, !enabled, cred.IsApproved(host))
+	}
+}
+
+func TestDoWithAuthOTP(t *testing.T) {
+	var called uint32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&called, 1)
+		assert.Equal(t, basicAuth("user", "pass"), req.Header.Get("Authorization"))
+
+		by, err := ioutil.ReadAll(req.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, `{"Test":"OTP"}`, strings.TrimSpace(string(by)))
+
+		if req.Header.Get("X-GitHub-OTP") != "123456" {
+			w.Header().Set("X-GitHub-OTP", "required; app")
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "otp-askpass")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	prompts := filepath.Join(dir, "prompts")
+	askpass := filepath.Join(dir, "askpass")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$1\" >> %q\necho 123456\n", prompts)
+	require.Nil(t, ioutil.WriteFile(askpass, []byte(script), 0755))
+
+	for _, enabled := range []bool{false, true} {
+		atomic.StoreUint32(&called, 0)
+		os.Remove(prompts)
+
+		cred := newMockCredentialHelper()
+		c, err := NewClient(lfshttp.NewContext(git.NewReadOnlyConfig("", ""),
+			map[string]string{
+				"GIT_ASKPASS": askpass,
+			}, map[string]string{
+				"lfs.url":           srv.URL + "/repo/lfs",
+				"lfs.credentialotp": fmt.Sprintf("%t", enabled),
+			},
+		))
+		require.Nil(t, err)
+		c.Credentials = cred
+
+		req, err := http.NewRequest("POST", srv.URL+"/repo/lfs/foo", nil)
+		require.Nil(t, err)
+		require.Nil(t, MarshalToRequest(req, &authRequest{Test: "OTP"}))
+
+		res, err := c.DoWithAuthNoRetry("", creds.NewAccess(creds.BasicAccess, srv.URL+"/repo/lfs"), req)
+		by, _ := ioutil.ReadFile(prompts)
+
+		if !enabled {
+			assert.NotNil(t, err)
+			assert.EqualValues(t, 1, called)
+			assert.Empty(t, by)
+			continue
+		}
+
+		require.Nil(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.EqualValues(t, 2, called)
+		assert.Equal(t, fmt.Sprintf("One-time code for %q\n", srv.URL+"/repo/lfs"), string(by))
+		assert.Empty(t, req.Header.Get("X-GitHub-OTP"))
+
+		// the credentials were accepted, so are approved, not rejected
+		assert.True(t, cred.IsApproved(creds.Creds{
+			"protocol": "http",
+			"host":     srv.Listener.Addr().String(),
+			"password": "pass",
+		}))
+	}
+}
+
 type mockCredentialHelper struct {
 	Approved map[string]creds.Creds
 }
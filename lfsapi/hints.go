@@ -0,0 +1,58 @@
+package lfsapi
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/git-lfs/git-lfs/creds"
+)
+
+// ckey is a type that wraps a string for package-unique context.Context keys.
+type ckey string
+
+// contextKeyCredentialHints is a context.Context key for storing the
+// creds.CredentialHints for a given request.
+const contextKeyCredentialHints ckey = "credential-hints"
+
+// WithCredentialHints stores the given hints on the given http.Request, to be
+// passed to credential helpers when filling credentials for it.
+func WithCredentialHints(req *http.Request, hints creds.CredentialHints) *http.Request {
+	ctx := context.WithValue(req.Context(), contextKeyCredentialHints, hints)
+	return req.WithContext(ctx)
+}
+
+// credentialHints returns the creds.CredentialHints stored on the given
+// http.Request. If there are none, or they have no operation, the operation is
+// guessed from the request method.
+func credentialHints(req *http.Request) creds.CredentialHints {
+	hints, _ := req.Context().Value(contextKeyCredentialHints).(creds.CredentialHints)
+	if len(hints.Operation) == 0 {
+		hints.Operation = getReqOperation(req)
+	}
+	return hints
+}
+
+// contextKeyDeferredApprovals is a context.Context key for storing the
+// creds.PendingApprovals into which the approval of credentials used for a
+// given request is deferred.
+const contextKeyDeferredApprovals ckey = "deferred-approvals"
+
+// WithDeferredApprovals stores the given pending approvals on the given
+// http.Request, so that if "lfs.credentialdeferapproval" is enabled, the
+// credentials used for it are approved only once they are committed. If
+// pending is nil, the request is returned unchanged.
+func WithDeferredApprovals(req *http.Request, pending *creds.PendingApprovals) *http.Request {
+	if pending == nil {
+		return req
+	}
+
+	ctx := context.WithValue(req.Context(), contextKeyDeferredApprovals, pending)
+	return req.WithContext(ctx)
+}
+
+// deferredApprovals returns the creds.PendingApprovals stored on the given
+// http.Request, or nil if there are none.
+func deferredApprovals(req *http.Request) *creds.PendingApprovals {
+	pending, _ := req.Context().Value(contextKeyDeferredApprovals).(*creds.PendingApprovals)
+	return pending
+}
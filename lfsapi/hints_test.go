@@ -0,0 +1,24 @@
+package lfsapi
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/git-lfs/git-lfs/creds"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithCredentialHints(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/", nil)
+	req = WithCredentialHints(req, creds.CredentialHints{Operation: "download", Ref: "refs/heads/main"})
+
+	assert.Equal(t, creds.CredentialHints{Operation: "download", Ref: "refs/heads/main"}, credentialHints(req))
+}
+
+func TestCredentialHintsOnUnannotatedRequest(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	assert.Equal(t, creds.CredentialHints{Operation: "download"}, credentialHints(req))
+
+	req, _ = http.NewRequest("PUT", "/", nil)
+	assert.Equal(t, creds.CredentialHints{Operation: "upload"}, credentialHints(req))
+}
@@ -14,6 +14,13 @@ func (c *Client) doWithNegotiate(req *http.Request, credWrapper creds.Credential
 	// first.  The other is that we're using NTLM, which we try second with
 	// single sign-on credentials.  Finally, if that also fails, we fall
 	// back to prompting for credentials with NTLM and trying that.
+	//
+	// If the negotiate credential helper is enabled, its Kerberos token is
+	// tried before all of these.
+	if res, ok := c.doWithNegotiateCreds(req, credWrapper); ok {
+		return res, nil
+	}
+
 	res, err := c.doWithAccess(req, "", nil, creds.NegotiateAccess)
 	if err == nil || errors.IsAuthError(err) {
 		if res.StatusCode != 401 {
@@ -37,3 +44,39 @@ func (c *Client) doWithNegotiate(req *http.Request, credWrapper creds.Credential
 
 	return c.ntlmReAuth(req, credWrapper, true)
 }
+
+// doWithNegotiateCreds sends the request with a token filled by the negotiate
+// credential helper alone, if it is enabled, for the same credential URL as
+// the given credentials, returning the response and true if the token was
+// accepted. The negotiate credential helper is not consulted when the client
+// was given its own credential helper.
+func (c *Client) doWithNegotiateCreds(req *http.Request, credWrapper creds.CredentialHelperWrapper) (*http.Response, bool) {
+	if c.credContext == nil || c.Credentials != nil || credWrapper.Url == nil {
+		return nil, false
+	}
+
+	negotiateWrapper, ok := c.credContext.GetNegotiateCredentialHelper(credWrapper.Url, credentialHints(req))
+	if !ok {
+		return nil, false
+	}
+	if err := negotiateWrapper.FillCreds(); err != nil || negotiateWrapper.Creds[creds.CredsAuthtype] != "Negotiate" {
+		return nil, false
+	}
+
+	// The token is sent as it is, rather than through the transport for
+	// NegotiateAccess, which would replace it with one of its own.
+	setRequestAuthFromCreds(req, negotiateWrapper.Creds)
+	res, err := c.doWithAccess(req, "", nil, creds.BasicAccess)
+	if err == nil || errors.IsAuthError(err) {
+		if res != nil && res.StatusCode != 401 {
+			return res, true
+		}
+	}
+
+	tracerx.Printf("negotiate credential helper token not accepted")
+	if res != nil {
+		res.Body.Close()
+	}
+	req.Header.Del("Authorization")
+	return nil, false
+}
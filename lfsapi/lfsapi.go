@@ -2,12 +2,15 @@ package lfsapi
 
 import (
 	"fmt"
+	"regexp"
 	"sync"
 
+	"github.com/git-lfs/git-lfs/config"
 	"github.com/git-lfs/git-lfs/creds"
 	"github.com/git-lfs/git-lfs/errors"
 	"github.com/git-lfs/git-lfs/lfshttp"
 	"github.com/git-lfs/go-ntlm/ntlm"
+	"github.com/rubyist/tracerx"
 )
 
 type Client struct {
@@ -19,7 +22,28 @@ type Client struct {
 
 	credContext *creds.CredentialHelperContext
 
-	client *lfshttp.Client
+	// deferApprovals is true if credentials should not be approved when a
+	// request carrying pending approvals succeeds, but only once those are
+	// committed.
+	deferApprovals bool
+
+	// otpHeader is the header with which a server asks for a one-time
+	// code, and which carries it, if "lfs.credentialotp" is enabled.
+	otpHeader string
+
+	// authFailureBody, if non-nil, matches the body of a successful
+	// response which is nonetheless an authentication failure, as set by
+	// "lfs.authfailurebodypattern".
+	authFailureBody *regexp.Regexp
+
+	// tlsPeerNames holds the primary name of the verified certificate
+	// last presented by each host, for hosts whose credentials are keyed
+	// on it by "credential.<url>.useTLSPeerName".
+	tlsPeerNames   map[string]string
+	tlsPeerNamesMu sync.Mutex
+
+	urlConfig *config.URLConfig
+	client    *lfshttp.Client
 }
 
 func NewClient(ctx lfshttp.Context) (*Client, error) {
@@ -28,7 +52,6 @@ func NewClient(ctx lfshttp.Context) (*Client, error) {
 	}
 
 	gitEnv := ctx.GitEnv()
-	osEnv := ctx.OSEnv()
 
 	httpClient, err := lfshttp.NewClient(ctx)
 	if err != nil {
@@ -38,7 +61,26 @@ func NewClient(ctx lfshttp.Context) (*Client, error) {
 	c := &Client{
 		Endpoints:   NewEndpointFinder(ctx),
 		client:      httpClient,
-		credContext: creds.NewCredentialHelperContext(gitEnv, osEnv),
+		credContext: httpClient.CredentialHelperContext(),
+		urlConfig:   config.NewURLConfig(gitEnv),
+
+		deferApprovals: gitEnv.Bool("lfs.credentialdeferapproval", false),
+	}
+
+	if gitEnv.Bool("lfs.credentialotp", false) {
+		c.otpHeader = defaultOTPHeader
+		if header, ok := gitEnv.Get("lfs.credentialotpheader"); ok && len(header) > 0 {
+			c.otpHeader = header
+		}
+	}
+
+	if pattern, ok := gitEnv.Get("lfs.authfailurebodypattern"); ok && len(pattern) > 0 {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			tracerx.Printf("api: ignoring invalid lfs.authfailurebodypattern %q: %s", pattern, err)
+		} else {
+			c.authFailureBody = re
+		}
 	}
 
 	return c, nil
@@ -0,0 +1,66 @@
+package lfsapi
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/git-lfs/git-lfs/creds"
+	"github.com/git-lfs/git-lfs/lfshttp"
+	"github.com/rubyist/tracerx"
+)
+
+// doWithProxyCreds retries a request which an HTTP proxy refused with "407
+// Proxy Authentication Required", having filled credentials for the proxy
+// through the credential helpers. The credentials for the origin, if any, are
+// left on the request, so that each part of it is authenticated separately.
+func (c *Client) doWithProxyCreds(req *http.Request, credWrapper creds.CredentialHelperWrapper, access creds.Access, via []*http.Request, res *http.Response, err error) (*http.Response, error) {
+	proxyURL, perr := c.client.ProxyURL(req)
+	if perr != nil || proxyURL == nil {
+		return res, err
+	}
+
+	proxyWrapper := c.credContext.GetProxyCredentialHelper(c.Credentials, proxyURL)
+	if ferr := proxyWrapper.FillCreds(); ferr != nil {
+		return res, ferr
+	}
+	tracerx.Printf("creds: filled proxy credentials for %s", creds.SanitizeURL(proxyURL))
+
+	c.client.SetProxyUserinfo(proxyURL, url.UserPassword(
+		proxyWrapper.Creds[creds.CredsUsername], proxyWrapper.Creds[creds.CredsPassword]))
+	if res != nil {
+		res.Body.Close()
+	}
+
+	res, err = c.doWithCreds(req, credWrapper, access, via)
+	proxyRes := proxyResponse(res, err)
+	if proxyRes == nil {
+		// The proxy never answered, so the credentials were not tried.
+		return res, err
+	}
+	if proxyRes.StatusCode == http.StatusProxyAuthRequired {
+		c.client.SetProxyUserinfo(proxyURL, nil)
+		proxyWrapper.CredentialHelper.Reject(proxyWrapper.Creds)
+		return res, err
+	}
+
+	c.approve(req, proxyWrapper)
+	return res, err
+}
+
+// proxyAuthRequired returns whether an HTTP proxy refused the request with "407
+// Proxy Authentication Required".
+func proxyAuthRequired(res *http.Response, err error) bool {
+	proxyRes := proxyResponse(res, err)
+	return proxyRes != nil && proxyRes.StatusCode == http.StatusProxyAuthRequired
+}
+
+// proxyResponse returns the response of the HTTP proxy, if any, to a request
+// which returned the given response and error: the response itself, for a
+// plain HTTP request, or, for an HTTPS request whose tunnel was refused, the
+// proxy's response to the CONNECT request.
+func proxyResponse(res *http.Response, err error) *http.Response {
+	if res != nil {
+		return res
+	}
+	return lfshttp.ProxyConnectResponse(err)
+}
@@ -0,0 +1,178 @@
+package lfsapi
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/git-lfs/git-lfs/creds"
+	"github.com/git-lfs/git-lfs/errors"
+	"github.com/git-lfs/git-lfs/git"
+	"github.com/git-lfs/git-lfs/lfshttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoWithAuthProxyCredentials(t *testing.T) {
+	var called uint32
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&called, 1)
+		assert.Equal(t, "lfs.example.invalid", req.URL.Host)
+
+		if req.Header.Get("Proxy-Authorization") != basicAuth("user", "pass") {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="proxy"`)
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+
+		// the origin credentials are still sent to the origin
+		assert.Equal(t, basicAuth("user", "pass"), req.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	cred := newMockCredentialHelper()
+	c, err := NewClient(lfshttp.NewContext(git.NewReadOnlyConfig("", ""),
+		nil, map[string]string{
+			"lfs.url":    "http://lfs.example.invalid/repo/lfs",
+			"http.proxy": proxy.URL,
+		},
+	))
+	require.Nil(t, err)
+	c.Credentials = cred
+
+	req, err := http.NewRequest("GET", "http://lfs.example.invalid/repo/lfs/foo", nil)
+	require.Nil(t, err)
+
+	res, err := c.DoWithAuth("", creds.NewAccess(creds.BasicAccess, "http://lfs.example.invalid/repo/lfs"), req)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.EqualValues(t, 2, called)
+
+	proxyCreds := creds.Creds{
+		"protocol": "http",
+		"host":     proxy.Listener.Addr().String(),
+		"password": "pass",
+	}
+	assert.True(t, cred.IsApproved(proxyCreds))
+	assert.Equal(t, creds.CredsScopeProxy, cred.Approved[credsToKey(proxyCreds)][creds.CredsScope])
+	assert.True(t, cred.IsApproved(creds.Creds{
+		"protocol": "http",
+		"host":     "lfs.example.invalid",
+		"password": "pass",
+	}))
+}
+
+func TestDoWithAuthProxyCredentialsRejected(t *testing.T) {
+	var called uint32
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&called, 1)
+		w.Header().Set("Proxy-Authenticate", `Basic realm="proxy"`)
+		w.WriteHeader(http.StatusProxyAuthRequired)
+	}))
+	defer proxy.Close()
+
+	cred := newMockCredentialHelper()
+	c, err := NewClient(lfshttp.NewContext(git.NewReadOnlyConfig("", ""),
+		nil, map[string]string{
+			"lfs.url":    "http://lfs.example.invalid/repo/lfs",
+			"http.proxy": proxy.URL,
+		},
+	))
+	require.Nil(t, err)
+	c.Credentials = cred
+
+	req, err := http.NewRequest("GET", "http://lfs.example.invalid/repo/lfs/foo", nil)
+	require.Nil(t, err)
+
+	res, _ := c.DoWithAuth("", creds.NewAccess(creds.NoneAccess, "http://lfs.example.invalid/repo/lfs"), req)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, http.StatusProxyAuthRequired, res.StatusCode)
+	}
+	// the request is only retried once with proxy credentials
+	assert.EqualValues(t, 2, called)
+	assert.Empty(t, cred.Approved)
+}
+
+func TestProxyAuthRequired(t *testing.T) {
+	refused := func(status int) error {
+		return &url.Error{Op: "Get", URL: "https://lfs.example.invalid", Err: errors.Wrap(
+			&lfshttp.ProxyConnectError{Response: &http.Response{StatusCode: status}}, "lfs")}
+	}
+
+	assert.True(t, proxyAuthRequired(&http.Response{StatusCode: 407}, nil))
+	assert.False(t, proxyAuthRequired(&http.Response{StatusCode: 401}, nil))
+	assert.True(t, proxyAuthRequired(nil, refused(407)))
+	assert.False(t, proxyAuthRequired(nil, refused(403)))
+	assert.False(t, proxyAuthRequired(nil, errors.New("proxyconnect tcp: Proxy Authentication Required")))
+	assert.False(t, proxyAuthRequired(nil, errors.New("connection refused")))
+	assert.False(t, proxyAuthRequired(nil, nil))
+}
+
+func TestDoWithAuthProxyCredentialsConnect(t *testing.T) {
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer origin.Close()
+
+	var connects uint32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddUint32(&connects, 1)
+		require.Equal(t, "CONNECT", req.Method)
+		assert.Equal(t, "lfs.example.invalid:443", req.Host)
+
+		if req.Header.Get("Proxy-Authorization") != basicAuth("user", "pass") {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="proxy"`)
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+
+		// tunnel to the origin, whatever the requested host
+		upstream, err := net.Dial("tcp", origin.Listener.Addr().String())
+		require.Nil(t, err)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		require.Nil(t, err)
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go func() {
+			io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		go func() {
+			io.Copy(conn, upstream)
+			conn.Close()
+		}()
+	}))
+	defer proxy.Close()
+
+	cred := newMockCredentialHelper()
+	c, err := NewClient(lfshttp.NewContext(git.NewReadOnlyConfig("", ""),
+		nil, map[string]string{
+			"lfs.url":        "https://lfs.example.invalid/repo/lfs",
+			"http.proxy":     proxy.URL,
+			"http.sslverify": "false",
+		},
+	))
+	require.Nil(t, err)
+	c.Credentials = cred
+
+	req, err := http.NewRequest("GET", "https://lfs.example.invalid/repo/lfs/foo", nil)
+	require.Nil(t, err)
+
+	res, err := c.DoWithAuth("", creds.NewAccess(creds.NoneAccess, "https://lfs.example.invalid/repo/lfs"), req)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.EqualValues(t, 2, atomic.LoadUint32(&connects))
+
+	// the proxy's credentials are approved once it opened the tunnel
+	assert.True(t, cred.IsApproved(creds.Creds{
+		"protocol": "http",
+		"host":     proxy.Listener.Addr().String(),
+		"password": "pass",
+	}))
+}
@@ -68,6 +68,11 @@ type Client struct {
 	credHelperContext *creds.CredentialHelperContext
 
 	sshTries int
+
+	// proxyUsers maps the host of each HTTP proxy to the user information
+	// with which to authenticate to it, as set by SetProxyUserinfo.
+	proxyUsers map[string]*url.Userinfo
+	proxyMu    sync.Mutex
 }
 
 func NewClient(ctx Context) (*Client, error) {
@@ -100,6 +105,12 @@ func NewClient(ctx Context) (*Client, error) {
 		credHelperContext:   creds.NewCredentialHelperContext(gitEnv, osEnv),
 	}
 
+	// Credential helpers which make HTTP requests, such as to an OAuth
+	// token endpoint, honour the same proxy and TLS configuration.
+	c.credHelperContext.SetTransport(func(u *url.URL) (http.RoundTripper, error) {
+		return c.Transport(u, creds.NoneAccess)
+	})
+
 	return c, nil
 }
 
@@ -115,6 +126,14 @@ func (c *Client) URLConfig() *config.URLConfig {
 	return c.uc
 }
 
+// CredentialHelperContext returns the context with which the client fills
+// credentials, such as the passphrases of client certificates. Clients built
+// on this one should fill all their credentials with it too, so that they are
+// cached and prompted for once per process, however many requests need them.
+func (c *Client) CredentialHelperContext() *creds.CredentialHelperContext {
+	return c.credHelperContext
+}
+
 func (c *Client) NewRequest(method string, e Endpoint, suffix string, body interface{}) (*http.Request, error) {
 	if strings.HasPrefix(e.Url, "file://") {
 		// Initial `\n` to avoid overprinting `Downloading LFS...`.
@@ -412,9 +431,10 @@ func (c *Client) Transport(u *url.URL, access creds.AccessMode) (http.RoundTripp
 		tlstime = 30
 	}
 	tr := &http.Transport{
-		Proxy:               proxyFromClient(c),
-		TLSHandshakeTimeout: time.Duration(tlstime) * time.Second,
-		MaxIdleConnsPerHost: concurrentTransfers,
+		Proxy:                  proxyFromClient(c),
+		OnProxyConnectResponse: onProxyConnectResponse,
+		TLSHandshakeTimeout:    time.Duration(tlstime) * time.Second,
+		MaxIdleConnsPerHost:    concurrentTransfers,
 	}
 
 	activityTimeout := 30
@@ -1,6 +1,8 @@
 package lfshttp
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
@@ -43,8 +45,85 @@ func proxyFromClient(c *Client) func(req *http.Request) (*url.URL, error) {
 		if u.Host == "localhost" {
 			u.Host = "127.0.0.1"
 		}
-		return cfg.ProxyFunc()(&u)
+		proxyURL, err := cfg.ProxyFunc()(&u)
+		if err != nil || proxyURL == nil {
+			return proxyURL, err
+		}
+
+		if user := c.proxyUserinfo(proxyURL); user != nil {
+			proxyURL.User = user
+		}
+		return proxyURL, nil
+	}
+}
+
+// ProxyURL returns the URL of the HTTP proxy through which the given request
+// is sent, or nil if it is sent directly.
+func (c *Client) ProxyURL(req *http.Request) (*url.URL, error) {
+	return proxyFromClient(c)(req)
+}
+
+// SetProxyUserinfo sets the user information with which to authenticate to the
+// given HTTP proxy, overriding any given in its URL. A nil user clears it.
+func (c *Client) SetProxyUserinfo(proxy *url.URL, user *url.Userinfo) {
+	c.proxyMu.Lock()
+	defer c.proxyMu.Unlock()
+
+	if user == nil {
+		delete(c.proxyUsers, proxy.Host)
+		return
+	}
+	if c.proxyUsers == nil {
+		c.proxyUsers = make(map[string]*url.Userinfo)
+	}
+	c.proxyUsers[proxy.Host] = user
+}
+
+func (c *Client) proxyUserinfo(proxy *url.URL) *url.Userinfo {
+	c.proxyMu.Lock()
+	defer c.proxyMu.Unlock()
+
+	return c.proxyUsers[proxy.Host]
+}
+
+// ProxyConnectError is the error with which an HTTPS request fails when the
+// HTTP proxy answers the CONNECT request for its tunnel with anything but "200
+// OK". Response is the proxy's response, whose body is already closed.
+type ProxyConnectError struct {
+	Response *http.Response
+}
+
+func (e *ProxyConnectError) Error() string {
+	return fmt.Sprintf("proxyconnect: %s", e.Response.Status)
+}
+
+// onProxyConnectResponse is the OnProxyConnectResponse hook of each transport,
+// which keeps the proxy's response to a refused CONNECT request in the error,
+// rather than only its status text.
+func onProxyConnectResponse(_ context.Context, _ *url.URL, _ *http.Request, res *http.Response) error {
+	if res.StatusCode != http.StatusOK {
+		return &ProxyConnectError{Response: res}
+	}
+	return nil
+}
+
+// ProxyConnectResponse returns the HTTP proxy's response to the CONNECT request
+// of an HTTPS request which failed with the given error, if the proxy refused
+// to open a tunnel for it, or nil otherwise.
+func ProxyConnectResponse(err error) *http.Response {
+	for err != nil {
+		switch e := err.(type) {
+		case *ProxyConnectError:
+			return e.Response
+		case interface{ Cause() error }:
+			err = e.Cause()
+		case interface{ Unwrap() error }:
+			err = e.Unwrap()
+		default:
+			return nil
+		}
 	}
+	return nil
 }
 
 func getProxyServers(u *url.URL, urlCfg *config.URLConfig, osEnv config.Environment) (httpsProxy string, httpProxy string, noProxy string) {
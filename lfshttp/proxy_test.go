@@ -2,6 +2,7 @@ package lfshttp
 
 import (
 	"net/http"
+	"net/url"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -126,3 +127,27 @@ func TestSocksProxyFromEnvironment(t *testing.T) {
 	assert.Equal(t, "proxy-from-env:3128", proxyURL.Host)
 	assert.Nil(t, err)
 }
+
+func TestProxyUserinfo(t *testing.T) {
+	c, err := NewClient(NewContext(nil, nil, map[string]string{
+		"http.proxy": "http://someone@proxy-from-git-config:8080",
+	}))
+	require.Nil(t, err)
+
+	req, err := http.NewRequest("GET", "http://some-host.com:123/foo/bar", nil)
+	require.Nil(t, err)
+
+	proxyURL, err := c.ProxyURL(req)
+	require.Nil(t, err)
+	assert.Equal(t, "someone", proxyURL.User.String())
+
+	c.SetProxyUserinfo(proxyURL, url.UserPassword("someone", "s3cr3t"))
+	proxyURL, err = c.ProxyURL(req)
+	require.Nil(t, err)
+	assert.Equal(t, "someone:s3cr3t", proxyURL.User.String())
+
+	c.SetProxyUserinfo(proxyURL, nil)
+	proxyURL, err = c.ProxyURL(req)
+	require.Nil(t, err)
+	assert.Equal(t, "someone", proxyURL.User.String())
+}
@@ -8,7 +8,9 @@ import (
 	"os"
 	"os/exec"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/rubyist/tracerx"
 )
@@ -150,3 +152,61 @@ func init() {
 		env = append(env, kv)
 	}
 }
+
+// envFuncs maps the names of environment variables to the functions giving
+// their values for new commands, as set by SetEnvFunc.
+var (
+	envFuncs   = make(map[string]func() string)
+	envFuncsMu sync.Mutex
+)
+
+// SetEnvFunc arranges for the environment variable with the given name to be
+// set, for each command created afterwards, to the value f returns when the
+// command is created, in place of any value inherited by this process. If f
+// returns the empty string, the variable is unset. A nil f undoes this.
+func SetEnvFunc(name string, f func() string) {
+	envFuncsMu.Lock()
+	defer envFuncsMu.Unlock()
+
+	if f == nil {
+		delete(envFuncs, name)
+	} else {
+		envFuncs[name] = f
+	}
+}
+
+// commandEnv returns the environment for a new command: that of this process,
+// without GIT_TRACE and GIT_INTERNAL_SUPER_PREFIX, and with the variables set
+// by SetEnvFunc.
+func commandEnv() []string {
+	envFuncsMu.Lock()
+	funcs := make(map[string]func() string, len(envFuncs))
+	for name, f := range envFuncs {
+		funcs[name] = f
+	}
+	envFuncsMu.Unlock()
+
+	if len(funcs) == 0 {
+		return env
+	}
+
+	merged := make([]string, 0, len(env)+len(funcs))
+	for _, kv := range env {
+		if _, ok := funcs[strings.SplitN(kv, "=", 2)[0]]; !ok {
+			merged = append(merged, kv)
+		}
+	}
+
+	names := make([]string, 0, len(funcs))
+	for name := range funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if value := funcs[name](); len(value) > 0 {
+			merged = append(merged, name+"="+value)
+		}
+	}
+	return merged
+}
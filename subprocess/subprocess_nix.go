@@ -9,6 +9,6 @@ import (
 // ExecCommand is a small platform specific wrapper around os/exec.Command
 func ExecCommand(name string, arg ...string) *Cmd {
 	cmd := exec.Command(name, arg...)
-	cmd.Env = env
+	cmd.Env = commandEnv()
 	return newCmd(cmd)
 }
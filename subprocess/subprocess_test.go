@@ -89,3 +89,25 @@ func TestFormatForShell(t *testing.T) {
 		t.Run(desc, c.Assert)
 	}
 }
+
+func TestSetEnvFunc(t *testing.T) {
+	oldEnv := env
+	defer func() { env = oldEnv }()
+	env = []string{"A=1", "GIT_LFS_TEST=inherited"}
+
+	value := "first"
+	SetEnvFunc("GIT_LFS_TEST", func() string { return value })
+	defer SetEnvFunc("GIT_LFS_TEST", nil)
+
+	assert.Equal(t, []string{"A=1", "GIT_LFS_TEST=first"}, ExecCommand("true").Env)
+
+	// the value is taken when each command is created
+	value = "second"
+	assert.Equal(t, []string{"A=1", "GIT_LFS_TEST=second"}, ExecCommand("true").Env)
+
+	value = ""
+	assert.Equal(t, []string{"A=1"}, ExecCommand("true").Env)
+
+	SetEnvFunc("GIT_LFS_TEST", nil)
+	assert.Equal(t, env, ExecCommand("true").Env)
+}
@@ -46,7 +46,7 @@ begin_test "askpass: push with bad askpass"
   git config "credential.helper" ""
   GIT_TERMINAL_PROMPT=0 GIT_ASKPASS="lfs-askpass-2" SSH_ASKPASS="dont-call-me" GIT_TRACE=1 git push origin master 2>&1 | tee push.log
   grep "filling with GIT_ASKPASS" push.log                     # attempt askpass
-  grep 'credential fill error: exec: "lfs-askpass-2"' push.log # askpass fails
+  grep 'credential fill error: askpass: exec: "lfs-askpass-2"' push.log # askpass fails
   grep "creds: git credential fill" push.log                   # attempt git credential
 )
 end_test
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/git-lfs/git-lfs/creds"
 	"github.com/git-lfs/git-lfs/fs"
 	"github.com/git-lfs/git-lfs/lfsapi"
 	"github.com/rubyist/tracerx"
@@ -22,6 +23,7 @@ type adapterBase struct {
 	transferImpl transferImplementation
 	apiClient    *lfsapi.Client
 	remote       string
+	approvals    *creds.PendingApprovals
 	jobChan      chan *job
 	debugging    bool
 	cb           ProgressCallback
@@ -77,6 +79,7 @@ func (a *adapterBase) Direction() Direction {
 func (a *adapterBase) Begin(cfg AdapterConfig, cb ProgressCallback) error {
 	a.apiClient = cfg.APIClient()
 	a.remote = cfg.Remote()
+	a.approvals = cfg.DeferredApprovals()
 	a.cb = cb
 	a.jobChan = make(chan *job, 100)
 	a.debugging = a.apiClient.OSEnv().Bool("GIT_TRANSFER_TRACE", false) ||
@@ -228,6 +231,7 @@ func (a *adapterBase) doHTTP(t *Transfer, req *http.Request) (*http.Response, er
 		return a.apiClient.Do(req)
 	}
 	endpoint := endpointURL(req.URL.String(), t.Oid)
+	req = lfsapi.WithDeferredApprovals(req, a.approvals)
 	return a.apiClient.DoWithAuthNoRetry(a.remote, a.apiClient.Endpoints.AccessFor(endpoint), req)
 }
 
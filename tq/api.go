@@ -3,6 +3,7 @@ package tq
 import (
 	"time"
 
+	"github.com/git-lfs/git-lfs/creds"
 	"github.com/git-lfs/git-lfs/errors"
 	"github.com/git-lfs/git-lfs/git"
 	"github.com/git-lfs/git-lfs/lfsapi"
@@ -33,19 +34,29 @@ type BatchResponse struct {
 }
 
 func Batch(m *Manifest, dir Direction, remote string, remoteRef *git.Ref, objects []*Transfer) (*BatchResponse, error) {
+	return doBatch(m, dir, remote, remoteRef, objects, nil)
+}
+
+// doBatch performs a batch request as Batch does, deferring the approval of the
+// credentials used for it into the given pending approvals, if any.
+func doBatch(m *Manifest, dir Direction, remote string, remoteRef *git.Ref, objects []*Transfer, approvals *creds.PendingApprovals) (*BatchResponse, error) {
 	if len(objects) == 0 {
 		return &BatchResponse{}, nil
 	}
 
-	return m.batchClient().Batch(remote, &batchRequest{
+	return m.batchClient().doBatch(remote, &batchRequest{
 		Operation:            dir.String(),
 		Objects:              objects,
 		TransferAdapterNames: m.GetAdapterNames(dir),
 		Ref:                  &batchRef{Name: remoteRef.Refspec()},
-	})
+	}, approvals)
 }
 
 func (c *tqClient) Batch(remote string, bReq *batchRequest) (*BatchResponse, error) {
+	return c.doBatch(remote, bReq, nil)
+}
+
+func (c *tqClient) doBatch(remote string, bReq *batchRequest, approvals *creds.PendingApprovals) (*BatchResponse, error) {
 	bRes := &BatchResponse{}
 	if len(bReq.Objects) == 0 {
 		return bRes, nil
@@ -70,6 +81,12 @@ func (c *tqClient) Batch(remote string, bReq *batchRequest) (*BatchResponse, err
 
 	tracerx.Printf("api: batch %d files", len(bReq.Objects))
 
+	var ref string
+	if bReq.Ref != nil {
+		ref = bReq.Ref.Name
+	}
+	req = lfsapi.WithCredentialHints(req, creds.CredentialHints{Operation: bReq.Operation, Ref: ref})
+	req = lfsapi.WithDeferredApprovals(req, approvals)
 	req = c.Client.LogRequest(req, "lfs.batch")
 	res, err := c.DoAPIRequestWithAuth(remote, lfshttp.WithRetries(req, c.MaxRetries))
 	if err != nil {
@@ -156,7 +156,7 @@ func (a *basicUploadAdapter) DoTransfer(ctx interface{}, t *Transfer, cb Progres
 	io.Copy(ioutil.Discard, res.Body)
 	res.Body.Close()
 
-	return verifyUpload(a.apiClient, a.remote, t)
+	return verifyUpload(a.apiClient, a.remote, a.approvals, t)
 }
 
 func (a *adapterBase) setContentTypeFor(req *http.Request, r io.ReadSeeker) error {
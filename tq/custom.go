@@ -321,7 +321,7 @@ func (a *customAdapter) DoTransfer(ctx interface{}, t *Transfer, cb ProgressCall
 					return fmt.Errorf("failed to copy downloaded file: %v", err)
 				}
 			} else if a.direction == Upload {
-				if err = verifyUpload(a.apiClient, a.remote, t); err != nil {
+				if err = verifyUpload(a.apiClient, a.remote, a.approvals, t); err != nil {
 					return err
 				}
 			}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/git-lfs/git-lfs/creds"
 	"github.com/git-lfs/git-lfs/errors"
 	"github.com/git-lfs/git-lfs/lfsapi"
 	"github.com/git-lfs/git-lfs/tools"
@@ -193,12 +194,16 @@ type AdapterConfig interface {
 	APIClient() *lfsapi.Client
 	ConcurrentTransfers() int
 	Remote() string
+	// DeferredApprovals returns the pending approvals into which the
+	// approval of credentials used by the adapter's requests is deferred.
+	DeferredApprovals() *creds.PendingApprovals
 }
 
 type adapterConfig struct {
 	apiClient           *lfsapi.Client
 	concurrentTransfers int
 	remote              string
+	approvals           *creds.PendingApprovals
 }
 
 func (c *adapterConfig) ConcurrentTransfers() int {
@@ -213,6 +218,10 @@ func (c *adapterConfig) Remote() string {
 	return c.remote
 }
 
+func (c *adapterConfig) DeferredApprovals() *creds.PendingApprovals {
+	return c.approvals
+}
+
 // Adapter is implemented by types which can upload and/or download LFS
 // file content to a remote store. Each Adapter accepts one or more requests
 // which it may schedule and parallelise in whatever way it chooses, clients of
@@ -191,6 +191,11 @@ type TransferQueue struct {
 	manifest *Manifest
 	rc       *retryCounter
 
+	// approvals holds the credentials used by this queue whose approval is
+	// deferred until Wait, so that they are approved only if all of its
+	// transfers succeeded.
+	approvals *creds.PendingApprovals
+
 	// unsupportedContentType indicates whether the transfer queue ever saw
 	// an HTTP 422 response indicating that their upload destination does
 	// not support Content-Type detection.
@@ -296,6 +301,9 @@ func NewTransferQueue(dir Direction, manifest *Manifest, remote string, options
 
 	q.rc.MaxRetries = q.manifest.maxRetries
 	q.client.MaxRetries = q.manifest.maxRetries
+	if client := q.manifest.APIClient(); client != nil {
+		q.approvals = client.NewDeferredApprovals()
+	}
 
 	if q.batchSize <= 0 {
 		q.batchSize = defaultBatchSize
@@ -522,7 +530,7 @@ func (q *TransferQueue) enqueueAndCollectRetriesFor(batch batch) (batch, error)
 		// Query the Git LFS server for what transfer method to use and
 		// details such as URLs, authentication, etc.
 		var err error
-		bRes, err = Batch(q.manifest, q.direction, q.remote, q.ref, batch.ToTransfers())
+		bRes, err = doBatch(q.manifest, q.direction, q.remote, q.ref, batch.ToTransfers(), q.approvals)
 		if err != nil {
 			// If there was an error making the batch API call, mark all of
 			// the objects for retry, and return them along with the error
@@ -895,6 +903,7 @@ func (q *TransferQueue) toAdapterCfg(e lfshttp.Endpoint) AdapterConfig {
 		concurrentTransfers: concurrency,
 		apiClient:           apiClient,
 		remote:              q.remote,
+		approvals:           q.approvals,
 	}
 }
 
@@ -928,6 +937,14 @@ func (q *TransferQueue) Wait() {
 	q.meter.Flush()
 	q.errorwait.Wait()
 
+	if q.approvals != nil {
+		if len(q.errors) == 0 {
+			q.approvals.Commit()
+		} else {
+			q.approvals.Discard()
+		}
+	}
+
 	if q.unsupportedContentType {
 		for _, line := range contentTypeWarning {
 			fmt.Fprintf(os.Stderr, "info: %s\n", line)
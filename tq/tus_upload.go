@@ -158,7 +158,7 @@ func (a *tusUploadAdapter) DoTransfer(ctx interface{}, t *Transfer, cb ProgressC
 	io.Copy(ioutil.Discard, res.Body)
 	res.Body.Close()
 
-	return verifyUpload(a.apiClient, a.remote, t)
+	return verifyUpload(a.apiClient, a.remote, a.approvals, t)
 }
 
 func configureTusAdapter(m *Manifest) {
@@ -3,6 +3,7 @@ package tq
 import (
 	"net/http"
 
+	"github.com/git-lfs/git-lfs/creds"
 	"github.com/git-lfs/git-lfs/lfsapi"
 	"github.com/git-lfs/git-lfs/tools"
 	"github.com/rubyist/tracerx"
@@ -13,7 +14,7 @@ const (
 	defaultMaxVerifyAttempts = 3
 )
 
-func verifyUpload(c *lfsapi.Client, remote string, t *Transfer) error {
+func verifyUpload(c *lfsapi.Client, remote string, approvals *creds.PendingApprovals, t *Transfer) error {
 	action, err := t.Actions.Get("verify")
 	if err != nil {
 		return err
@@ -44,6 +45,7 @@ func verifyUpload(c *lfsapi.Client, remote string, t *Transfer) error {
 	mv := c.GitEnv().Int(maxVerifiesConfigKey, defaultMaxVerifyAttempts)
 	mv = tools.MaxInt(defaultMaxVerifyAttempts, mv)
 	req = c.LogRequest(req, "lfs.verify")
+	req = lfsapi.WithDeferredApprovals(req, approvals)
 
 	for i := 1; i <= mv; i++ {
 		tracerx.Printf("tq: verify %s attempt #%d (max: %d)", t.Oid[:7], i, mv)
@@ -20,7 +20,7 @@ func TestVerifyWithoutAction(t *testing.T) {
 		Size: 123,
 	}
 
-	assert.Nil(t, verifyUpload(c, "origin", tr))
+	assert.Nil(t, verifyUpload(c, "origin", nil, tr))
 }
 
 func TestVerifySuccess(t *testing.T) {
@@ -67,6 +67,6 @@ func TestVerifySuccess(t *testing.T) {
 		},
 	}
 
-	assert.Nil(t, verifyUpload(c, "origin", tr))
+	assert.Nil(t, verifyUpload(c, "origin", nil, tr))
 	assert.EqualValues(t, 1, called)
 }